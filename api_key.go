@@ -0,0 +1,467 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	nanoid "github.com/matoous/go-nanoid"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	apiKeyHeader = "X-Api-Key"
+	apiKeyScheme = "ApiKey "
+	// apiKeyTouchInterval is how often the time an API key was last used is written to the store.
+	apiKeyTouchInterval = time.Minute
+)
+
+// ApiKey is the server-side record of an API key. The secret part of the key is only ever stored as a hash.
+//
+// API keys are sent as "{id}.{secret}" in either the X-Api-Key header or the Authorization header
+// (eg 'ApiKey {id}.{secret}').
+//
+// API keys are denied by default: a session authenticated with an API key isn't logged in, so it only passes resolvers
+// that call core.Core.RequireScope with one of the key's scopes.
+type ApiKey struct {
+	// Id identifies the API key and is the part of the key before the ".".
+	Id string
+	// Name is a human readable name for the API key (e.g. the name of the integration).
+	Name string
+	// Subject is the subject that the API key acts as.
+	Subject string
+	// Scopes limits what the API key is allowed to do.
+	Scopes []string
+	// Hash is the SHA-256 hash of the secret part of the key.
+	Hash string
+	// CreatedAt is when the API key was created.
+	CreatedAt time.Time
+	// LastUsedAt is when the API key was last used, nil if it has never been used.
+	// It's only updated once a minute, so it can be up to a minute behind.
+	LastUsedAt *time.Time
+	// RevokedAt is when the API key was revoked, nil if it's still active.
+	RevokedAt *time.Time
+}
+
+// ApiKeyStore persists API keys.
+type ApiKeyStore interface {
+	// Create stores a new API key.
+	Create(c *Core, key *ApiKey) error
+	// Find returns the API key with the given id, or sql.ErrNoRows if it doesn't exist.
+	Find(c *Core, id string) (*ApiKey, error)
+	// List returns every API key that belongs to the given subject.
+	List(c *Core, subject string) ([]*ApiKey, error)
+	// Touch sets when the API key with the given id was last used. It's called at most once a minute per API key.
+	Touch(c *Core, id string, lastUsedAt time.Time) error
+	// Revoke revokes the API key with the given id. It returns sql.ErrNoRows if the API key doesn't exist.
+	Revoke(c *Core, id string) error
+}
+
+// apiKeys is the store used to authenticate API keys.
+var apiKeys ApiKeyStore = NewMemoryApiKeyStore()
+
+// memoryApiKeyStore
+type memoryApiKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]ApiKey
+}
+
+// NewMemoryApiKeyStore returns an ApiKeyStore that keeps API keys in memory.
+// It's the default store, but API keys are lost on restart and aren't shared between instances.
+func NewMemoryApiKeyStore() ApiKeyStore {
+	return &memoryApiKeyStore{keys: map[string]ApiKey{}}
+}
+
+// Create
+func (m *memoryApiKeyStore) Create(_ *Core, key *ApiKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.Id] = *key
+	return nil
+}
+
+// Find
+func (m *memoryApiKeyStore) Find(_ *Core, id string) (*ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &key, nil
+}
+
+// List
+func (m *memoryApiKeyStore) List(_ *Core, subject string) ([]*ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []*ApiKey{}
+	for _, key := range m.keys {
+		if key.Subject == subject {
+			key := key
+			keys = append(keys, &key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Touch
+func (m *memoryApiKeyStore) Touch(_ *Core, id string, lastUsedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.keys[id]; ok {
+		key.LastUsedAt = &lastUsedAt
+		m.keys[id] = key
+	}
+	return nil
+}
+
+// Revoke
+func (m *memoryApiKeyStore) Revoke(_ *Core, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		m.keys[id] = key
+	}
+	return nil
+}
+
+// sqlApiKeyStore
+type sqlApiKeyStore struct{}
+
+// NewSqlApiKeyStore returns an ApiKeyStore that keeps API keys in the api_keys table of *core.Core.Db.
+// Take a look at the template's migrations to see what the table should look like.
+func NewSqlApiKeyStore() ApiKeyStore {
+	return sqlApiKeyStore{}
+}
+
+// Create
+func (sqlApiKeyStore) Create(c *Core, key *ApiKey) error {
+	_, err := c.Db.ExecContext(c.Context,
+		"INSERT INTO api_keys (api_key_id, name, subject, scopes, hash, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		key.Id, key.Name, key.Subject, strings.Join(key.Scopes, " "), key.Hash, key.CreatedAt)
+	return err
+}
+
+// Find
+func (sqlApiKeyStore) Find(c *Core, id string) (*ApiKey, error) {
+	return scanApiKey(c.Db.QueryRowContext(c.Context,
+		"SELECT api_key_id, name, subject, scopes, hash, created_at, last_used_at, revoked_at FROM api_keys WHERE api_key_id = $1",
+		id))
+}
+
+// List
+func (sqlApiKeyStore) List(c *Core, subject string) ([]*ApiKey, error) {
+	rows, err := c.Db.QueryContext(c.Context,
+		"SELECT api_key_id, name, subject, scopes, hash, created_at, last_used_at, revoked_at FROM api_keys WHERE subject = $1 ORDER BY created_at",
+		subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*ApiKey{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Touch
+func (sqlApiKeyStore) Touch(c *Core, id string, lastUsedAt time.Time) error {
+	_, err := c.Db.ExecContext(c.Context, "UPDATE api_keys SET last_used_at = $2 WHERE api_key_id = $1", id, lastUsedAt)
+	return err
+}
+
+// Revoke
+func (sqlApiKeyStore) Revoke(c *Core, id string) error {
+	result, err := c.Db.ExecContext(c.Context,
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE api_key_id = $1",
+		id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// scanApiKey
+func scanApiKey(row interface{ Scan(...interface{}) error }) (*ApiKey, error) {
+	key := &ApiKey{}
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.Id, &key.Name, &key.Subject, &scopes, &key.Hash, &key.CreatedAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
+
+// CreateApiKey creates an API key for the given subject that is limited to the given scopes.
+// The returned key is the only time the secret is available, it should be shown to the user once and then discarded.
+func (c *Core) CreateApiKey(subject, name string, scopes ...string) (string, *ApiKey, error) {
+	id, err := nanoid.Nanoid()
+	if err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	key := &ApiKey{
+		Id:        id,
+		Name:      name,
+		Subject:   subject,
+		Scopes:    scopes,
+		Hash:      hashApiKeySecret(secret),
+		CreatedAt: time.Now(),
+	}
+	if err = apiKeys.Create(c, key); err != nil {
+		return "", nil, err
+	}
+	c.Audit(AuditApiKeyCreated, id, map[string]interface{}{"subject": subject, "name": name, "scopes": scopes})
+
+	return id + "." + secret, key, nil
+}
+
+// RotateApiKey replaces the API key with the given id with a new key that has the same name, subject and scopes.
+func (c *Core) RotateApiKey(id string) (string, *ApiKey, error) {
+	old, err := apiKeys.Find(c, id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, apiKey, err := c.CreateApiKey(old.Subject, old.Name, old.Scopes...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, apiKey, c.RevokeApiKey(id)
+}
+
+// RevokeApiKey revokes the API key with the given id.
+func (c *Core) RevokeApiKey(id string) error {
+	if err := apiKeys.Revoke(c, id); err != nil {
+		return err
+	}
+	c.Audit(AuditApiKeyRevoked, id, nil)
+	return nil
+}
+
+// revokeSubjectApiKeys revokes every API key of the given subject that was created before the given time.
+func (c *Core) revokeSubjectApiKeys(subject string, before time.Time) error {
+	keys, err := apiKeys.List(c, subject)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.RevokedAt == nil && key.CreatedAt.Before(before) {
+			if err = c.RevokeApiKey(key.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ApiKeys returns every API key that belongs to the given subject.
+func (c *Core) ApiKeys(subject string) ([]*ApiKey, error) {
+	return apiKeys.List(c, subject)
+}
+
+// RequireScope returns an error if the current session isn't allowed to use the given scope. Resolvers that call it
+// accept API keys with the scope as well as logged in sessions, the key's subject is available through
+// Session.Subject and Session.UserId.
+func (c *Core) RequireScope(scope string) error {
+	if !c.Session.HasScope(scope) {
+		return NewError(c, KindInsufficientScope, "You're missing the '"+scope+"' scope")
+	}
+	return nil
+}
+
+// hashApiKeySecret
+func hashApiKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// getApiKeyString returns the API key within the request, or an empty string if there isn't one.
+func getApiKeyString(c *Core) string {
+	if key := c.Request.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	if auth := c.Request.Header.Get("Authorization"); strings.HasPrefix(auth, apiKeyScheme) {
+		return auth[len(apiKeyScheme):]
+	}
+	return ""
+}
+
+// apiKeySession is a Session that is authenticated with an API key.
+type apiKeySession struct {
+	core   *Core
+	apiKey *ApiKey
+}
+
+// startApiKeySession verifies the given API key and attaches its session to the *core.Core.
+func startApiKeySession(c *Core, key string) error {
+	c.Session = &apiKeySession{core: c}
+
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return NewError(c, KindInvalidApiKey, "API keys must be in the format '{id}.{secret}'")
+	}
+
+	apiKey, err := apiKeys.Find(c, parts[0])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NewError(c, KindInvalidApiKey)
+		}
+		return NewError(c, err)
+	}
+
+	if apiKey.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(hashApiKeySecret(parts[1]))) != 1 {
+		return NewError(c, KindInvalidApiKey)
+	}
+
+	// writing on every request would make every request wait on the store
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		apiKey.LastUsedAt = &now
+		if err = apiKeys.Touch(c, apiKey.Id, now); err != nil {
+			c.Logger.Error("failed to touch api key", "error", err, "apiKeyId", apiKey.Id)
+		}
+	}
+
+	c.Session = &apiKeySession{core: c, apiKey: apiKey}
+	return nil
+}
+
+// IsLoggedIn always returns false, API keys are only allowed to use the resolvers that require one of their scopes.
+// See core.Core.RequireScope.
+func (s *apiKeySession) IsLoggedIn() bool {
+	return false
+}
+
+// IsAnonymous always returns true, see IsLoggedIn.
+func (s *apiKeySession) IsAnonymous() bool {
+	return true
+}
+
+// AccessToken returns an empty string, the API key is never given back to the client.
+func (s *apiKeySession) AccessToken() string {
+	return ""
+}
+
+// RefreshAccessToken always returns false, API keys don't expire and aren't logged in.
+func (s *apiKeySession) RefreshAccessToken() bool {
+	return false
+}
+
+// UserId returns the API key's subject as an int.
+func (s *apiKeySession) UserId() int {
+	if s.apiKey == nil {
+		return 0
+	}
+	id, err := strconv.Atoi(s.apiKey.Subject)
+	if err != nil {
+		s.core.Logger.DPanic("could not parse api key's subject to an int", "error", err)
+	}
+	return id
+}
+
+// Subject returns the API key's subject.
+func (s *apiKeySession) Subject() string {
+	if s.apiKey == nil {
+		return ""
+	}
+	return s.apiKey.Subject
+}
+
+// ServiceId
+func (s *apiKeySession) ServiceId() string {
+	return ""
+}
+
+// HasScope
+func (s *apiKeySession) HasScope(scope string) bool {
+	if s.apiKey == nil {
+		return false
+	}
+	for _, sc := range s.apiKey.Scopes {
+		if sc == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Login does nothing, API key sessions can't log in as another subject.
+func (s *apiKeySession) Login(int) {
+	s.core.Logger.DPanic("cannot login with an api key session")
+}
+
+// LoginSubject does nothing, API key sessions can't log in as another subject.
+func (s *apiKeySession) LoginSubject(string) {
+	s.core.Logger.DPanic("cannot login with an api key session")
+}
+
+// Logout does nothing, use Revoke to revoke the API key.
+func (s *apiKeySession) Logout() {}
+
+// LogoutEverywhere does nothing, use Revoke to revoke the API key.
+func (s *apiKeySession) LogoutEverywhere() {}
+
+// Revoke revokes the API key.
+func (s *apiKeySession) Revoke() {
+	if s.apiKey == nil {
+		return
+	}
+	if err := s.core.RevokeApiKey(s.apiKey.Id); err != nil {
+		s.core.Logger.Error("failed to revoke api key", "error", err, "apiKeyId", s.apiKey.Id)
+	}
+	*s = apiKeySession{core: s.core}
+}
+
+// MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
+func (s *apiKeySession) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("mode", "apiKey")
+	if s.apiKey != nil {
+		enc.AddString("apiKeyId", s.apiKey.Id)
+		enc.AddString("sub", s.apiKey.Subject)
+		enc.AddString("scopes", strings.Join(s.apiKey.Scopes, " "))
+	}
+	return nil
+}
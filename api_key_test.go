@@ -0,0 +1,143 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newApiKeyTestCore returns a *core.Core for a request that sends the given API key.
+func newApiKeyTestCore(key string) *Core {
+	c := newTestCore()
+	c.Request.Header.Set(apiKeyHeader, key)
+	return c
+}
+
+func TestApiKey(t *testing.T) {
+	defer func(s ApiKeyStore) { apiKeys = s }(apiKeys)
+	defer func(s []AuditSink) { auditSinks = s }(auditSinks)
+	apiKeys = NewMemoryApiKeyStore()
+	sink := &memoryAuditSink{}
+	auditSinks = []AuditSink{sink}
+
+	key, apiKey, err := newTestCore().CreateApiKey("1", "ci", "todos:read")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key, apiKey.Id+"."))
+	require.NotContains(t, apiKey.Hash, key[len(apiKey.Id)+1:])
+
+	c := newApiKeyTestCore(key)
+	require.NoError(t, c.StartSession())
+	require.Equal(t, "1", c.Session.Subject())
+	require.Equal(t, 1, c.Session.UserId())
+
+	// the key is also accepted in the authorization header
+	c = newTestCore()
+	c.Request.Header.Set("Authorization", apiKeyScheme+key)
+	require.NoError(t, c.StartSession())
+	require.Equal(t, "1", c.Session.Subject())
+
+	for _, invalid := range []string{"no_dot", apiKey.Id + ".wrong", "unknown.secret"} {
+		err = newApiKeyTestCore(invalid).StartSession()
+		require.Error(t, err, invalid)
+		require.Equal(t, KindInvalidApiKey, err.(Error).Kind, invalid)
+	}
+
+	// revoking the key through its session is audited like every other revocation
+	sink.events = nil
+	c.Session.Revoke()
+	require.Equal(t, []string{AuditApiKeyRevoked}, sink.actions())
+	require.Equal(t, "1", sink.events[0].Actor)
+	err = newApiKeyTestCore(key).StartSession()
+	require.Error(t, err)
+	require.Equal(t, KindInvalidApiKey, err.(Error).Kind)
+}
+
+func TestApiKey_Scopes(t *testing.T) {
+	defer func(s ApiKeyStore) { apiKeys = s }(apiKeys)
+	apiKeys = NewMemoryApiKeyStore()
+
+	key, _, err := newTestCore().CreateApiKey("1", "ci", "todos:read")
+	require.NoError(t, err)
+	c := newApiKeyTestCore(key)
+	require.NoError(t, c.StartSession())
+
+	// API keys are denied by default, only resolvers that require one of the key's scopes accept them
+	require.False(t, c.Session.IsLoggedIn())
+	require.True(t, c.Session.IsAnonymous())
+	require.False(t, c.Session.RefreshAccessToken())
+	require.NoError(t, c.RequireScope("todos:read"))
+	err = c.RequireScope("todos:write")
+	require.Error(t, err)
+	require.Equal(t, KindInsufficientScope, err.(Error).Kind)
+
+	// keys without scopes can't do anything
+	key, _, err = newTestCore().CreateApiKey("1", "unused")
+	require.NoError(t, err)
+	c = newApiKeyTestCore(key)
+	require.NoError(t, c.StartSession())
+	require.Error(t, c.RequireScope("todos:read"))
+
+	// logged in sessions aren't limited by scopes
+	c = newTestCore()
+	c.Session.LoginSubject("1")
+	require.NoError(t, c.RequireScope("todos:write"))
+}
+
+func TestApiKey_Touch(t *testing.T) {
+	defer func(s ApiKeyStore) { apiKeys = s }(apiKeys)
+	apiKeys = NewMemoryApiKeyStore()
+
+	c := newTestCore()
+	key, apiKey, err := c.CreateApiKey("1", "ci", "todos:read")
+	require.NoError(t, err)
+
+	require.NoError(t, newApiKeyTestCore(key).StartSession())
+	stored, err := apiKeys.Find(c, apiKey.Id)
+	require.NoError(t, err)
+	require.NotNil(t, stored.LastUsedAt)
+	first := *stored.LastUsedAt
+
+	// using the key again within a minute doesn't write to the store
+	require.NoError(t, newApiKeyTestCore(key).StartSession())
+	stored, err = apiKeys.Find(c, apiKey.Id)
+	require.NoError(t, err)
+	require.Equal(t, first, *stored.LastUsedAt)
+
+	require.NoError(t, apiKeys.Touch(c, apiKey.Id, time.Now().Add(-2*apiKeyTouchInterval)))
+	require.NoError(t, newApiKeyTestCore(key).StartSession())
+	stored, err = apiKeys.Find(c, apiKey.Id)
+	require.NoError(t, err)
+	require.True(t, stored.LastUsedAt.After(first))
+}
+
+func TestApiKey_RevokeSubject(t *testing.T) {
+	defer func(s ApiKeyStore) { apiKeys = s }(apiKeys)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	apiKeys = NewMemoryApiKeyStore()
+	refreshTokens = NewMemoryRefreshTokenStore()
+	denylist = NewMemoryAccessTokenDenylist()
+
+	key, _, err := newTestCore().CreateApiKey("1", "ci", "todos:read")
+	require.NoError(t, err)
+	other, _, err := newTestCore().CreateApiKey("2", "ci", "todos:read")
+	require.NoError(t, err)
+
+	// logging out everywhere also revokes the subject's API keys
+	c := newTestCore()
+	c.Session.LoginSubject("1")
+	c.Session.LogoutEverywhere()
+
+	require.Error(t, newApiKeyTestCore(key).StartSession())
+	require.NoError(t, newApiKeyTestCore(other).StartSession())
+
+	// keys created after the cutoff keep working
+	c = newTestCore()
+	key, _, err = c.CreateApiKey("2", "new", "todos:read")
+	require.NoError(t, err)
+	require.NoError(t, c.RevokeSubject("2", time.Now().Add(-time.Hour)))
+	require.NoError(t, newApiKeyTestCore(key).StartSession())
+	require.NoError(t, newApiKeyTestCore(other).StartSession())
+}
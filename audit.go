@@ -0,0 +1,223 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	nanoid "github.com/matoous/go-nanoid"
+	"go.uber.org/zap/zapcore"
+)
+
+// Audit actions that are recorded automatically.
+const (
+	// AuditLogin is recorded when a subject logs in.
+	AuditLogin = "session.login"
+	// AuditLoginPartially is recorded when a subject logs in, but still needs to verify their second factor.
+	AuditLoginPartially = "session.login_partially"
+	// AuditLoginFailed is recorded when a login fails. The target is the identifier that was used.
+	AuditLoginFailed = "session.login_failed"
+	// AuditLockout is recorded when an identifier or IP address is locked out after too many failed logins.
+	AuditLockout = "session.lockout"
+	// AuditLogout is recorded when a subject logs out.
+	AuditLogout = "session.logout"
+	// AuditLogoutEverywhere is recorded when a subject logs out of every device.
+	AuditLogoutEverywhere = "session.logout_everywhere"
+	// AuditRefresh is recorded when an access token is refreshed.
+	AuditRefresh = "session.refresh"
+	// AuditRefreshTokenReused is recorded when a rotated refresh token is used again and its family is revoked.
+	AuditRefreshTokenReused = "session.refresh_token_reused"
+	// AuditSessionRevoked is recorded when a subject revokes one of their device sessions.
+	AuditSessionRevoked = "session.revoked"
+	// AuditImpersonate is recorded when a subject starts impersonating another subject.
+	AuditImpersonate = "session.impersonate"
+	// AuditStopImpersonating is recorded when a subject stops impersonating another subject.
+	AuditStopImpersonating = "session.stop_impersonating"
+	// AuditApiKeyCreated is recorded when an API key is created. The target is the API key's id.
+	AuditApiKeyCreated = "api_key.created"
+	// AuditApiKeyRevoked is recorded when an API key is revoked. The target is the API key's id.
+	AuditApiKeyRevoked = "api_key.revoked"
+	// AuditDenied is recorded whenever an authentication or authorization error is returned.
+	AuditDenied = "access.denied"
+)
+
+// AuditEvent is a record of a security-relevant event.
+type AuditEvent struct {
+	// Id uniquely identifies the event.
+	Id string `json:"id"`
+	// Time is when the event happened.
+	Time time.Time `json:"time"`
+	// Action is what happened (e.g. "session.login" or "todo.delete").
+	Action string `json:"action"`
+	// Actor is the subject that caused the event. If the subject is impersonating, it's the real subject.
+	// It's empty if the request was anonymous.
+	Actor string `json:"actor"`
+	// Target is what the action was performed on (e.g. the subject that logged in or the id of the deleted todo).
+	Target string `json:"target"`
+	// RequestId is the id of the request (*core.Core.Id).
+	RequestId string `json:"requestId"`
+	// Ip is the IP address of the request.
+	Ip string `json:"ip"`
+	// Metadata contains anything else that's relevant to the action.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
+func (e *AuditEvent) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", e.Id)
+	enc.AddTime("time", e.Time)
+	enc.AddString("action", e.Action)
+	enc.AddString("actor", e.Actor)
+	enc.AddString("target", e.Target)
+	enc.AddString("requestId", e.RequestId)
+	enc.AddString("ip", e.Ip)
+	if len(e.Metadata) > 0 {
+		_ = enc.AddReflected("metadata", e.Metadata)
+	}
+	return nil
+}
+
+// AuditSink records audit events.
+type AuditSink interface {
+	// Write records the event.
+	Write(c *Core, event *AuditEvent) error
+}
+
+// auditSinks are written to whenever an audit event is recorded.
+var auditSinks []AuditSink
+
+// Audit records an audit event in every configured AuditSink. The actor, request id and IP address are taken from
+// the current request. Failing to write to a sink is logged, but doesn't fail the request.
+func (c *Core) Audit(action, target string, metadata map[string]interface{}) {
+	if len(auditSinks) == 0 {
+		return
+	}
+
+	// this should never error
+	id, _ := nanoid.Nanoid()
+	event := &AuditEvent{
+		Id:        id,
+		Time:      time.Now(),
+		Action:    action,
+		Target:    target,
+		RequestId: c.Id,
+		Ip:        c.remoteIp(),
+		Metadata:  metadata,
+	}
+
+	// API key sessions aren't logged in, but still act as their subject
+	if c.Session != nil && c.Session.RealSubject() != "" {
+		event.Actor = c.Session.RealSubject()
+		if c.Session.IsImpersonating() || c.Session.ServiceId() != "" {
+			event.Metadata = map[string]interface{}{}
+			for k, v := range metadata {
+				event.Metadata[k] = v
+			}
+			if c.Session.IsImpersonating() {
+				event.Metadata["impersonating"] = c.Session.Subject()
+			}
+			if c.Session.ServiceId() != "" {
+				event.Metadata["service"] = c.Session.ServiceId()
+			}
+		}
+	}
+
+	for _, sink := range auditSinks {
+		if err := sink.Write(c, event); err != nil {
+			c.Logger.Error("failed to write audit event", "error", err, "action", action, "target", target)
+		}
+	}
+}
+
+// auditedKinds are the kinds of errors that record an AuditDenied event.
+var auditedKinds = map[int]bool{
+	KindUnauthorized.Code:              true,
+	KindInvalidCredentials.Code:        true,
+	KindRevokedAccessToken.Code:        true,
+	KindInvalidApiKey.Code:             true,
+	KindInvalidServiceCredentials.Code: true,
+	KindSecondFactorRequired.Code:      true,
+	KindInvalidSecondFactor.Code:       true,
+	KindInvalidSignedToken.Code:        true,
+	KindInsufficientScope.Code:         true,
+	KindImpersonationForbidden.Code:    true,
+	KindAccountLocked.Code:             true,
+}
+
+// auditError records an AuditDenied event if the error is an authentication or authorization error.
+func auditError(c *Core, e *Error) {
+	if c == nil || !auditedKinds[e.Kind.Code] {
+		return
+	}
+	c.Audit(AuditDenied, "", map[string]interface{}{
+		"code":    e.Kind.Code,
+		"title":   e.Kind.Title,
+		"message": e.Error(),
+	})
+}
+
+// logAuditSink
+type logAuditSink struct{}
+
+// NewLogAuditSink returns an AuditSink that logs audit events as JSON through *core.Core.Logger.
+func NewLogAuditSink() AuditSink {
+	return logAuditSink{}
+}
+
+// Write
+func (logAuditSink) Write(c *Core, event *AuditEvent) error {
+	c.Logger.Info("audit: "+event.Action, "audit", event)
+	return nil
+}
+
+// fileAuditSink
+type fileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink returns an AuditSink that appends audit events to the file at the given path, one JSON object per
+// line. The file is created if it doesn't exist.
+func NewFileAuditSink(path string) (AuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{file: file}, nil
+}
+
+// Write
+func (f *fileAuditSink) Write(_ *Core, event *AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	return err
+}
+
+// sqlAuditSink
+type sqlAuditSink struct{}
+
+// NewSqlAuditSink returns an AuditSink that inserts audit events into the audit_events table of *core.Core.Db.
+// Take a look at the template's migrations to see what the table should look like.
+func NewSqlAuditSink() AuditSink {
+	return sqlAuditSink{}
+}
+
+// Write
+func (sqlAuditSink) Write(c *Core, event *AuditEvent) error {
+	metadata, err := json.Marshal(event.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Db.ExecContext(c.Context,
+		"INSERT INTO audit_events (audit_event_id, action, actor, target, request_id, ip, metadata, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		event.Id, event.Action, event.Actor, event.Target, event.RequestId, event.Ip, string(metadata), event.Time)
+	return err
+}
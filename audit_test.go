@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryAuditSink
+type memoryAuditSink struct {
+	events []AuditEvent
+}
+
+// Write
+func (m *memoryAuditSink) Write(_ *Core, event *AuditEvent) error {
+	m.events = append(m.events, *event)
+	return nil
+}
+
+func (m *memoryAuditSink) actions() []string {
+	actions := make([]string, len(m.events))
+	for i, event := range m.events {
+		actions[i] = event.Action
+	}
+	return actions
+}
+
+func TestAudit_Session(t *testing.T) {
+	defer func(s []AuditSink) { auditSinks = s }(auditSinks)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+	sink := &memoryAuditSink{}
+	auditSinks = []AuditSink{sink}
+
+	c := newTestCore()
+	c.Id = "request"
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+	c.Session.LoginSubject("subject")
+	c.Audit("todo.delete", "1", map[string]interface{}{"title": "todo"})
+	_ = NewError(c, KindInsufficientScope)
+	_ = NewError(c, KindRowNotFound)
+
+	require.Equal(t, []string{AuditLogin, "todo.delete", AuditDenied}, sink.actions())
+	event := sink.events[1]
+	require.Equal(t, "subject", event.Actor)
+	require.Equal(t, "1", event.Target)
+	require.Equal(t, "request", event.RequestId)
+	require.Equal(t, "192.0.2.1", event.Ip)
+	require.Equal(t, "todo", event.Metadata["title"])
+	require.Equal(t, KindInsufficientScope.Code, sink.events[2].Metadata["code"])
+}
+
+func TestAudit_FileSink(t *testing.T) {
+	defer func(s []AuditSink) { auditSinks = s }(auditSinks)
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := NewFileAuditSink(path)
+	require.NoError(t, err)
+	auditSinks = []AuditSink{sink}
+
+	c := newTestCore()
+	c.Audit("first", "1", nil)
+	c.Audit("second", "2", nil)
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		actions = append(actions, event.Action)
+	}
+	require.Equal(t, []string{"first", "second"}, actions)
+}
@@ -53,33 +53,78 @@ type ServerConfig struct {
 	Port int `mapstructure:"port" validate:"required,min=1"`
 	// Cors contains the configuration about CORS.
 	Cors CorsConfig `mapstructure:"cors" validate:"required"`
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse proxies in front of the server. The client's
+	// IP address is only read from the X-Forwarded-For header when the request comes from one of them, since anyone
+	// can send the header. This is optional, if no trusted proxies are found, then the connection's address is used.
+	TrustedProxies []string `mapstructure:"trusted_proxies" validate:"dive,cidr|ip"`
 	// Jwt contains the configuration about JSON web tokens.
 	Jwt struct {
-		AccessToken   JwtConfig  `mapstructure:"access_token" validate:"required"`
-		RefreshToken  *JwtConfig `mapstructure:"refresh_token" validate:""`
-		RefreshCookie *struct {
-			Domain   string `mapstructure:"domain" validate:"hostname"`
-			HttpOnly bool   `mapstructure:"http_only" validate:"required"`
-			Path     string `mapstructure:"path" validate:"required,uri"`
-			SameSite string `mapstructure:"same_site" validate:"required,oneof=none lax strict"`
-			Secure   bool   `mapstructure:"secure" validate:""`
-		} `mapstructure:"refresh_cookie" validate:""`
+		AccessToken  JwtConfig  `mapstructure:"access_token" validate:"required"`
+		RefreshToken *JwtConfig `mapstructure:"refresh_token" validate:""`
+		// RefreshTokenReuseInterval is how long a rotated refresh token can still be used before its reuse is treated
+		// as theft and its whole family is revoked. This allows concurrent requests to refresh at the same time.
+		RefreshTokenReuseInterval time.Duration        `mapstructure:"refresh_token_reuse_interval" validate:"min=0"`
+		RefreshCookie             *RefreshCookieConfig `mapstructure:"refresh_cookie" validate:""`
+		// AutoRefresh indicates whether an expired access token should be refreshed with the refresh token cookie
+		// before any resolver runs, instead of failing the request. It requires a refresh token and an
+		// access_token_transport cookie or header, so the client receives the new access token.
+		AutoRefresh bool `mapstructure:"auto_refresh" validate:""`
+		// AccessTokenTransport contains the configuration about how access tokens are sent to and received from clients.
+		// This is optional, if no transport configuration is found, then access tokens are only available through
+		// Session.AccessToken and are searched for in the default order.
+		AccessTokenTransport *AccessTokenTransportConfig `mapstructure:"access_token_transport" validate:""`
 	} `mapstructure:"jwt" validate:"required"`
+	// Oidc contains the configuration about logging in through OpenID Connect providers.
+	// This is optional, if no oidc configuration is found, then the /auth routes aren't added.
+	Oidc *OidcConfig `mapstructure:"oidc" validate:""`
+	// TokenExchange contains the configuration about services that can exchange tokens.
+	// This is optional, if no token exchange configuration is found, then the /token route isn't added.
+	TokenExchange *TokenExchangeConfig `mapstructure:"token_exchange" validate:""`
+	// Impersonation contains the configuration about impersonating other subjects.
+	// This is optional, if no impersonation configuration is found, then sessions can't impersonate.
+	Impersonation *ImpersonationConfig `mapstructure:"impersonation" validate:""`
+	// Mfa contains the configuration about multi-factor authentication.
+	// This is optional, if no mfa configuration is found, then defaults are used.
+	Mfa *MfaConfig `mapstructure:"mfa" validate:""`
+	// LoginAttempts contains the configuration about throttling failed logins.
+	// This is optional, if no login attempts configuration is found, then failed logins aren't throttled.
+	LoginAttempts *LoginAttemptsConfig `mapstructure:"login_attempts" validate:""`
+	// SignedTokens contains the configuration about single-use tokens for password resets, email verification and
+	// magic links. This is optional, if no signed tokens configuration is found, then signed tokens can't be issued.
+	SignedTokens *SignedTokensConfig `mapstructure:"signed_tokens" validate:""`
+	// Visitor contains the configuration about anonymous visitor ids.
+	// This is optional, if no visitor configuration is found, then visitors aren't given an id.
+	Visitor *VisitorConfig `mapstructure:"visitor" validate:""`
+	// Session contains the configuration about sessions.
+	// This is optional, if no session configuration is found, then JWT sessions are used.
+	Session *SessionConfig `mapstructure:"session" validate:""`
 	// Log contains the configuration about logging.
 	Log LogConfig `mapstructure:"log" validate:"required"`
 	// Graphql contains the configuration about GraphQL.
 	Graphql GraphqlConfig `mapstructure:"graphql" validate:"required"`
+	// Locale contains the configuration about translating error and validation messages.
+	// This is optional, if no locale configuration is found, then messages are in English.
+	Locale *LocaleConfig `mapstructure:"locale" validate:""`
+	// ErrorCatalogPath is the route that serves the catalog of error kinds (see core.ErrorCatalog) as JSON, or as
+	// Markdown with ?format=markdown. This is optional, if no path is found, then the catalog isn't served.
+	ErrorCatalogPath string `mapstructure:"error_catalog_path" validate:"omitempty,startswith=/"`
 }
 
 // corsOptions
 func (svr *ServerConfig) corsOptions() cors.Options {
+	// browsers can only read the access token header if it's exposed
+	var exposedHeaders []string
+	if svr.Jwt.AccessTokenTransport != nil && svr.Jwt.AccessTokenTransport.Header != "" {
+		exposedHeaders = append(exposedHeaders, svr.Jwt.AccessTokenTransport.Header)
+	}
+
 	return cors.Options{
 		MaxAge:             int(svr.Cors.MaxAge.Seconds()),
 		AllowCredentials:   svr.Cors.AllowCredentials,
 		AllowedOrigins:     svr.Cors.AllowedOrigins,
 		AllowedMethods:     svr.Cors.AllowedMethods,
 		AllowedHeaders:     svr.Cors.AllowedHeaders,
-		ExposedHeaders:     nil,
+		ExposedHeaders:     exposedHeaders,
 		OptionsPassthrough: false,
 		Debug:              false,
 	}
@@ -146,11 +191,206 @@ type JwtConfig struct {
 	Secret string `mapstructure:"secret" validate:"required,min=20"`
 }
 
+// OidcConfig contains the configuration about logging in through OpenID Connect providers.
+//
+// Each provider gets a /auth/{provider}/login route that redirects to the provider, and a /auth/{provider}/callback
+// route that the provider redirects back to. The callback route must be registered with the provider.
+type OidcConfig struct {
+	// BaseUrl is the public url of the server that callback urls are built from (e.g. https://api.example.com).
+	BaseUrl string `mapstructure:"base_url" validate:"required,url"`
+	// SuccessUrl is where the user is redirected to after they've logged in.
+	SuccessUrl string `mapstructure:"success_url" validate:"required,url"`
+	// FailureUrl is where the user is redirected to if logging in failed. The error's code is added as the "error"
+	// query parameter.
+	FailureUrl string `mapstructure:"failure_url" validate:"required,url"`
+	// SecureCookies indicates whether the cookies used during the login flow should only be sent over HTTPS.
+	SecureCookies bool `mapstructure:"secure_cookies" validate:""`
+	// Providers contains the configuration about each provider, keyed by the name used in the provider's routes.
+	Providers map[string]OidcProviderConfig `mapstructure:"providers" validate:"required,min=1,dive"`
+}
+
+// OidcProviderConfig contains the configuration about an OpenID Connect provider.
+type OidcProviderConfig struct {
+	// Issuer is the provider's issuer url. The provider's configuration is discovered from
+	// {issuer}/.well-known/openid-configuration.
+	Issuer string `mapstructure:"issuer" validate:"required,url"`
+	// ClientId is the client id that was registered with the provider.
+	ClientId string `mapstructure:"client_id" validate:"required"`
+	// ClientSecret is the client secret that was registered with the provider.
+	ClientSecret string `mapstructure:"client_secret" validate:""`
+	// Scopes are the scopes requested from the provider. Defaults to openid, email and profile.
+	Scopes []string `mapstructure:"scopes" validate:""`
+}
+
+// TokenExchangeConfig contains the configuration about services that can mint delegated access tokens through the
+// /token route (RFC 8693).
+type TokenExchangeConfig struct {
+	// ExpiresAt indicates how long delegated access tokens are valid for. This should be short.
+	ExpiresAt time.Duration `mapstructure:"expires_at" validate:"required"`
+	// MaxAssertionAge indicates the longest a service assertion can be valid for.
+	MaxAssertionAge time.Duration `mapstructure:"max_assertion_age" validate:"required"`
+	// Services contains the credentials of each service, keyed by the service's id.
+	Services map[string]ServiceConfig `mapstructure:"services" validate:"required,min=1,dive"`
+}
+
+// ServiceConfig contains the credentials of a service and what it's allowed to exchange.
+type ServiceConfig struct {
+	// Secret is the key the service signs its assertions with.
+	Secret string `mapstructure:"secret" validate:"required,min=20"`
+	// Subjects are the subjects the service can act on behalf of without one of their access tokens
+	// (urn:scott-rc:core:params:oauth:token-type:subject). "*" allows every subject. If it's empty, the service can only
+	// exchange access tokens.
+	Subjects []string `mapstructure:"subjects" validate:""`
+	// Audiences are the audiences the service can request delegated access tokens for with the audience parameter.
+	// If it's empty, delegated access tokens always have the audience of server.jwt.access_token.
+	Audiences []string `mapstructure:"audiences" validate:""`
+}
+
+// ImpersonationConfig contains the configuration about impersonating other subjects.
+type ImpersonationConfig struct {
+	// Role is the role a subject must have to impersonate other subjects. See core.Options.Roles.
+	Role string `mapstructure:"role" validate:"required"`
+	// ExpiresAt indicates how long impersonation access tokens are valid for.
+	ExpiresAt time.Duration `mapstructure:"expires_at" validate:"required"`
+}
+
+// AccessTokenTransportConfig contains the configuration about how access tokens are sent to and received from clients.
+type AccessTokenTransportConfig struct {
+	// Cookie, if set, sends every access token that's issued to the client as an HttpOnly cookie.
+	Cookie *CookieConfig `mapstructure:"cookie" validate:""`
+	// Header, if set, is the response header every access token that's issued is sent in (e.g. "X-Access-Token").
+	Header string `mapstructure:"header" validate:""`
+	// Precedence is the order, from highest to lowest, that requests are searched for an access token.
+	// Possible values are "header", "query" and "cookie". Places that aren't listed aren't searched.
+	// Defaults to ["header", "query", "cookie"].
+	Precedence []string `mapstructure:"precedence" validate:"omitempty,dive,oneof=header query cookie"`
+}
+
+// RefreshCookieConfig contains the configuration about the refresh token cookie.
+type RefreshCookieConfig struct {
+	Domain   string `mapstructure:"domain" validate:"hostname"`
+	HttpOnly bool   `mapstructure:"http_only" validate:"required"`
+	Path     string `mapstructure:"path" validate:"required,uri"`
+	SameSite string `mapstructure:"same_site" validate:"required,oneof=none lax strict"`
+	Secure   bool   `mapstructure:"secure" validate:""`
+}
+
+// CookieConfig contains the configuration about a cookie that core sets. The cookie is always HttpOnly.
+type CookieConfig struct {
+	Domain   string `mapstructure:"domain" validate:"omitempty,hostname"`
+	Path     string `mapstructure:"path" validate:"required,uri"`
+	SameSite string `mapstructure:"same_site" validate:"required,oneof=none lax strict"`
+	Secure   bool   `mapstructure:"secure" validate:""`
+}
+
+// MfaConfig contains the configuration about multi-factor authentication.
+type MfaConfig struct {
+	// Issuer is the name authenticator apps display next to the account (usually the name of your application).
+	Issuer string `mapstructure:"issuer" validate:"required"`
+	// Skew indicates how many 30 second time steps a TOTP code can be off by in either direction.
+	Skew int `mapstructure:"skew" validate:"min=0,max=10"`
+	// PendingExpiresAt indicates how long a partially authenticated session has to verify its second factor.
+	PendingExpiresAt time.Duration `mapstructure:"pending_expires_at" validate:"required"`
+}
+
+// LoginAttemptsConfig contains the configuration about throttling failed logins.
+type LoginAttemptsConfig struct {
+	// BackoffAfter is how many logins of an identifier can fail in a row before there's a delay between attempts.
+	BackoffAfter int `mapstructure:"backoff_after" validate:"min=0"`
+	// BaseDelay is the delay after the first failure past BackoffAfter, it doubles with every failure after that.
+	BaseDelay time.Duration `mapstructure:"base_delay" validate:"required"`
+	// MaxDelay caps the delay between attempts.
+	MaxDelay time.Duration `mapstructure:"max_delay" validate:"required"`
+	// LockoutAfter is how many logins of an identifier can fail in a row before it's locked out.
+	LockoutAfter int `mapstructure:"lockout_after" validate:"required,min=1"`
+	// IpLockoutAfter is how many logins from an IP address can fail in a row before it's locked out. This should be
+	// higher than LockoutAfter since many users can share an IP address.
+	IpLockoutAfter int `mapstructure:"ip_lockout_after" validate:"required,min=1"`
+	// LockoutDuration indicates how long an identifier or IP address is locked out for.
+	LockoutDuration time.Duration `mapstructure:"lockout_duration" validate:"required"`
+	// ResetAfter indicates how long failed logins are remembered for. It must be at least LockoutDuration, otherwise
+	// the failures that caused a lockout are forgotten before it ends.
+	ResetAfter time.Duration `mapstructure:"reset_after" validate:"required,gtefield=LockoutDuration"`
+}
+
+// SignedTokensConfig contains the configuration about single-use tokens.
+type SignedTokensConfig struct {
+	// Secret is used to sign the tokens. It should be different from the access and refresh token secrets.
+	Secret string `mapstructure:"secret" validate:"required,min=20"`
+	// PasswordResetExpiresAt indicates how long a password reset token is valid for.
+	PasswordResetExpiresAt time.Duration `mapstructure:"password_reset_expires_at" validate:"required"`
+	// EmailVerificationExpiresAt indicates how long an email verification token is valid for.
+	EmailVerificationExpiresAt time.Duration `mapstructure:"email_verification_expires_at" validate:"required"`
+	// MagicLinkExpiresAt indicates how long a magic link token is valid for.
+	MagicLinkExpiresAt time.Duration `mapstructure:"magic_link_expires_at" validate:"required"`
+}
+
+// expiresAt returns how long a token for the given purpose is valid for.
+func (cfg *SignedTokensConfig) expiresAt(purpose TokenPurpose) (time.Duration, error) {
+	switch purpose {
+	case TokenPurposePasswordReset:
+		return cfg.PasswordResetExpiresAt, nil
+	case TokenPurposeEmailVerification:
+		return cfg.EmailVerificationExpiresAt, nil
+	case TokenPurposeMagicLink:
+		return cfg.MagicLinkExpiresAt, nil
+	default:
+		return 0, fmt.Errorf("unknown token purpose %q", purpose)
+	}
+}
+
+// LocaleConfig contains the configuration about translating error and validation messages.
+type LocaleConfig struct {
+	// Default is the locale used when a request doesn't ask for a supported one. It must also be supported.
+	Default string `mapstructure:"default" validate:"required"`
+	// Supported are the locales requests can ask for through the Accept-Language header. Validation messages are
+	// included for en, es, fr, id, ja, nl, pt_BR, ru, tr and zh. Other locales must be translated by the bundles, they
+	// are checked when the server starts.
+	Supported []string `mapstructure:"supported" validate:"required,min=1,dive,required"`
+	// Bundles is a JSON translation file, or a directory of them, in go-playground/universal-translator's format.
+	// Error kinds are translated with the keys "error.<code>.title" and "error.<code>.message", and validation
+	// messages with the validation rule (e.g. "required"). Validation messages of locales that aren't included start
+	// out in English, so translating them requires "override": true. This is optional.
+	Bundles string `mapstructure:"bundles" validate:""`
+}
+
+// VisitorConfig contains the configuration about anonymous visitor ids.
+type VisitorConfig struct {
+	// Secret is used to sign visitor ids so they can't be forged.
+	Secret string `mapstructure:"secret" validate:"required"`
+	// ExpiresAt indicates how long a visitor id is kept for.
+	ExpiresAt time.Duration `mapstructure:"expires_at" validate:"required"`
+	// Cookie contains the configuration about the visitor id cookie.
+	Cookie CookieConfig `mapstructure:"cookie" validate:"required"`
+}
+
+// SessionConfig contains the configuration about sessions.
+type SessionConfig struct {
+	// Mode indicates how sessions are represented (jwt, opaque).
+	// JWT sessions are stateless, opaque sessions are random ids that are stored server-side.
+	// Opaque session ids are sent to and received from clients like access tokens (see server.jwt.access_token_transport).
+	Mode string `mapstructure:"mode" validate:"required,oneof=jwt opaque"`
+	// Ttl indicates how long an opaque session lasts after it was last used.
+	Ttl time.Duration `mapstructure:"ttl" validate:"required"`
+}
+
 // Log contains the configuration about logging.
 type LogConfig struct {
 	// Level indicates the level the application should log at. Any levels greater than or equal to
 	// this will be logged. Log levels from least severe to highest: debug, info, warn, error
 	Level string `mapstructure:"level" validate:"required,oneof=debug info warn error"`
+	// ErrorReporting contains the configuration about Google Cloud Error Reporting.
+	// This is optional, if it's found, then errors with a severity of error or higher are logged in the format that
+	// Error Reporting picks up, including their stack trace.
+	ErrorReporting *ErrorReportingConfig `mapstructure:"error_reporting" validate:""`
+}
+
+// ErrorReportingConfig contains the configuration about Google Cloud Error Reporting.
+type ErrorReportingConfig struct {
+	// Service is the name errors are grouped under.
+	Service string `mapstructure:"service" validate:"required"`
+	// Version is the version of the service (e.g. a commit hash). This is optional.
+	Version string `mapstructure:"version" validate:""`
 }
 
 // GraphqlConfig contains the configuration about GraphQL.
@@ -221,6 +461,7 @@ func (cfg *Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("path", cfg.Path)
 	_ = enc.AddObject("server", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
 		enc.AddInt("port", cfg.Server.Port)
+		enc.AddString("trustedProxies", strings.Join(cfg.Server.TrustedProxies, ","))
 		_ = enc.AddObject("cors", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
 			enc.AddInt("maxAge", int(cfg.Server.Cors.MaxAge.Seconds()))
 			enc.AddBool("allowCredentials", cfg.Server.Cors.AllowCredentials)
@@ -270,7 +511,9 @@ func (cfg *Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 					enc.AddString("notBefore", cfg.Server.Jwt.RefreshToken.NotBefore.String())
 					return nil
 				}))
+				enc.AddString("refreshTokenReuseInterval", cfg.Server.Jwt.RefreshTokenReuseInterval.String())
 			}
+			enc.AddBool("autoRefresh", cfg.Server.Jwt.AutoRefresh)
 			if cfg.Server.Jwt.RefreshCookie != nil {
 				_ = enc.AddObject("refreshCookie", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
 					enc.AddString("domain", cfg.Server.Jwt.RefreshCookie.Domain)
@@ -281,16 +524,142 @@ func (cfg *Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 					return nil
 				}))
 			}
+			if transport := cfg.Server.Jwt.AccessTokenTransport; transport != nil {
+				_ = enc.AddObject("accessTokenTransport", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					if transport.Cookie != nil {
+						_ = enc.AddObject("cookie", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+							enc.AddString("domain", transport.Cookie.Domain)
+							enc.AddString("path", transport.Cookie.Path)
+							enc.AddString("sameSite", transport.Cookie.SameSite)
+							enc.AddBool("secure", transport.Cookie.Secure)
+							return nil
+						}))
+					}
+					enc.AddString("header", transport.Header)
+					enc.AddString("precedence", strings.Join(transport.Precedence, ","))
+					return nil
+				}))
+			}
 			return nil
 		}))
+		if cfg.Server.Oidc != nil {
+			_ = enc.AddObject("oidc", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("baseUrl", cfg.Server.Oidc.BaseUrl)
+				enc.AddString("successUrl", cfg.Server.Oidc.SuccessUrl)
+				enc.AddString("failureUrl", cfg.Server.Oidc.FailureUrl)
+				enc.AddBool("secureCookies", cfg.Server.Oidc.SecureCookies)
+				_ = enc.AddObject("providers", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					for name, provider := range cfg.Server.Oidc.Providers {
+						provider := provider
+						_ = enc.AddObject(name, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+							enc.AddString("issuer", provider.Issuer)
+							enc.AddString("clientId", provider.ClientId)
+							enc.AddString("scopes", strings.Join(provider.Scopes, " "))
+							return nil
+						}))
+					}
+					return nil
+				}))
+				return nil
+			}))
+		}
+		if cfg.Server.TokenExchange != nil {
+			_ = enc.AddObject("tokenExchange", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("expiresAt", cfg.Server.TokenExchange.ExpiresAt.String())
+				enc.AddString("maxAssertionAge", cfg.Server.TokenExchange.MaxAssertionAge.String())
+				_ = enc.AddArray("services", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+					for id := range cfg.Server.TokenExchange.Services {
+						enc.AppendString(id)
+					}
+					return nil
+				}))
+				return nil
+			}))
+		}
+		if cfg.Server.Impersonation != nil {
+			_ = enc.AddObject("impersonation", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("role", cfg.Server.Impersonation.Role)
+				enc.AddString("expiresAt", cfg.Server.Impersonation.ExpiresAt.String())
+				return nil
+			}))
+		}
+		if cfg.Server.Mfa != nil {
+			_ = enc.AddObject("mfa", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("issuer", cfg.Server.Mfa.Issuer)
+				enc.AddInt("skew", cfg.Server.Mfa.Skew)
+				enc.AddString("pendingExpiresAt", cfg.Server.Mfa.PendingExpiresAt.String())
+				return nil
+			}))
+		}
+		if cfg.Server.LoginAttempts != nil {
+			_ = enc.AddObject("loginAttempts", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddInt("backoffAfter", cfg.Server.LoginAttempts.BackoffAfter)
+				enc.AddString("baseDelay", cfg.Server.LoginAttempts.BaseDelay.String())
+				enc.AddString("maxDelay", cfg.Server.LoginAttempts.MaxDelay.String())
+				enc.AddInt("lockoutAfter", cfg.Server.LoginAttempts.LockoutAfter)
+				enc.AddInt("ipLockoutAfter", cfg.Server.LoginAttempts.IpLockoutAfter)
+				enc.AddString("lockoutDuration", cfg.Server.LoginAttempts.LockoutDuration.String())
+				enc.AddString("resetAfter", cfg.Server.LoginAttempts.ResetAfter.String())
+				return nil
+			}))
+		}
+		if cfg.Server.SignedTokens != nil {
+			_ = enc.AddObject("signedTokens", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("passwordResetExpiresAt", cfg.Server.SignedTokens.PasswordResetExpiresAt.String())
+				enc.AddString("emailVerificationExpiresAt", cfg.Server.SignedTokens.EmailVerificationExpiresAt.String())
+				enc.AddString("magicLinkExpiresAt", cfg.Server.SignedTokens.MagicLinkExpiresAt.String())
+				return nil
+			}))
+		}
+		if cfg.Server.Visitor != nil {
+			_ = enc.AddObject("visitor", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("expiresAt", cfg.Server.Visitor.ExpiresAt.String())
+				_ = enc.AddObject("cookie", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("domain", cfg.Server.Visitor.Cookie.Domain)
+					enc.AddString("path", cfg.Server.Visitor.Cookie.Path)
+					enc.AddString("sameSite", cfg.Server.Visitor.Cookie.SameSite)
+					enc.AddBool("secure", cfg.Server.Visitor.Cookie.Secure)
+					return nil
+				}))
+				return nil
+			}))
+		}
+		if cfg.Server.Session != nil {
+			_ = enc.AddObject("session", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("mode", cfg.Server.Session.Mode)
+				enc.AddString("ttl", cfg.Server.Session.Ttl.String())
+				return nil
+			}))
+		}
 		_ = enc.AddObject("log", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
 			enc.AddString("level", cfg.Server.Log.Level)
+			if cfg.Server.Log.ErrorReporting != nil {
+				_ = enc.AddObject("errorReporting", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("service", cfg.Server.Log.ErrorReporting.Service)
+					enc.AddString("version", cfg.Server.Log.ErrorReporting.Version)
+					return nil
+				}))
+			}
 			return nil
 		}))
 		_ = enc.AddObject("graphql", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
 			enc.AddString("schema", cfg.Server.Graphql.Schema)
 			return nil
 		}))
+		if cfg.Server.Locale != nil {
+			_ = enc.AddObject("locale", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("default", cfg.Server.Locale.Default)
+				_ = enc.AddArray("supported", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+					for _, locale := range cfg.Server.Locale.Supported {
+						enc.AppendString(locale)
+					}
+					return nil
+				}))
+				enc.AddString("bundles", cfg.Server.Locale.Bundles)
+				return nil
+			}))
+		}
+		enc.AddString("errorCatalogPath", cfg.Server.ErrorCatalogPath)
 		return nil
 	}))
 	if cfg.Database != nil {
@@ -4,11 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/go-playground/validator/v10"
+	graphqlErrors "github.com/graph-gophers/graphql-go/errors"
 
-	"github.com/dgrijalva/jwt-go"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -23,16 +26,23 @@ const ContextKey = contextKey(iota)
 //
 // *core.Core will always be attached to the context.Context in your resolver method. Use the ContextKey to retrieve it.
 type Core struct {
-	w          http.ResponseWriter
-	Context    context.Context
-	Config     Configuration
-	Db         *sql.DB
-	Id         string
-	Logger     Logger
-	Operations []string
-	Request    *http.Request
-	Session    Session
-	Validate   *validator.Validate
+	w         http.ResponseWriter
+	visitorId string
+	// panics are the panics recovered from resolvers by the errors graphql-go created from them, and loggedPanics are
+	// the ones that haven't been paired with their error yet, by the goroutine they occurred in
+	panics       map[*graphqlErrors.QueryError]*PanicError
+	loggedPanics map[uint64]*PanicError
+	panicsMu     sync.Mutex
+	Context      context.Context
+	Config       Configuration
+	Db           *sql.DB
+	Id           string
+	Locale       string
+	Logger       Logger
+	Operations   []string
+	Request      *http.Request
+	Session      Session
+	Validate     *validator.Validate
 }
 
 // AddOp adds an operation to the current core. Operations are used to display application specific
@@ -42,6 +52,56 @@ func (c *Core) AddOp(operation string) {
 	c.Logger.Debug(fmt.Sprintf("entering %s", operation))
 }
 
+// remoteIp returns the IP address of the client that sent the request, without its port.
+//
+// If the request came from one of server.trusted_proxies, X-Forwarded-For is read from right to left and the first
+// address that isn't a trusted proxy is the client's. Addresses to the left of it could have been sent by the client.
+func (c *Core) remoteIp() string {
+	if c.Request == nil {
+		return ""
+	}
+	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		ip = c.Request.RemoteAddr
+	}
+
+	trustedProxies := c.Config.CoreConfig().Server.TrustedProxies
+	if !isTrustedProxy(trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(c.Request.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		ip = addr
+		if !isTrustedProxy(trustedProxies, addr) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrustedProxy reports whether the IP address matches one of the trusted proxies' addresses or CIDR ranges.
+func isTrustedProxy(trustedProxies []string, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if proxyIp := net.ParseIP(proxy); proxyIp != nil && proxyIp.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 // MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
 func (c *Core) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("id", c.Id)
@@ -49,7 +109,7 @@ func (c *Core) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		enc.AddString("requestMethod", c.Request.Method)
 		enc.AddString("requestUrl", c.Request.URL.String())
 		enc.AddString("userAgent", c.Request.UserAgent())
-		enc.AddString("remoteIp", c.Request.RemoteAddr)
+		enc.AddString("remoteIp", c.remoteIp())
 		enc.AddString("referer", c.Request.Referer())
 		return nil
 	}))
@@ -59,24 +119,9 @@ func (c *Core) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		}
 		return nil
 	}))
-	_ = enc.AddObject("session", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
-		s := c.Session.(*session)
-		enc.AddString("token", s.accessTokenString)
-		if s.accessToken != nil {
-			_ = enc.AddObject("claims", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
-				claims := s.accessToken.Claims.(*jwt.StandardClaims)
-				enc.AddString("aud", claims.Audience)
-				enc.AddInt64("exp", claims.ExpiresAt)
-				enc.AddString("jti", claims.Id)
-				enc.AddInt64("iat", claims.IssuedAt)
-				enc.AddString("iss", claims.Issuer)
-				enc.AddInt64("nbf", claims.NotBefore)
-				enc.AddString("sub", claims.Subject)
-				return nil
-			}))
-		}
-		return nil
-	}))
+	if session, ok := c.Session.(zapcore.ObjectMarshaler); ok {
+		_ = enc.AddObject("session", session)
+	}
 	return nil
 }
 
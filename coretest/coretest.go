@@ -7,8 +7,6 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	"github.com/go-playground/validator/v10"
-
 	"github.com/scott-rc/core"
 
 	gonanoid "github.com/matoous/go-nanoid"
@@ -19,6 +17,10 @@ import (
 type Options struct {
 	Config                   core.Configuration
 	ResolverContextDecorator core.ResolverContextDecorator
+	// Validations, StructValidations and ValidationMessages are registered like core.Options' when the server starts.
+	Validations        []core.Validation
+	StructValidations  []core.StructValidation
+	ValidationMessages map[string]map[string]string
 }
 
 func NewCore(t *testing.T, opts Options) *core.Core {
@@ -28,13 +30,19 @@ func NewCore(t *testing.T, opts Options) *core.Core {
 	id, err := gonanoid.Nanoid()
 	require.NoError(t, err)
 
+	require.NoError(t, core.RegisterValidations(core.Options{
+		Validations:        opts.Validations,
+		StructValidations:  opts.StructValidations,
+		ValidationMessages: opts.ValidationMessages,
+	}))
+
 	c := &core.Core{
 		Id:         id,
 		Logger:     testLogger{t},
 		Config:     opts.Config,
 		Request:    httptest.NewRequest("POST", "/api", nil),
 		Operations: []string{},
-		Validate:   validator.New(),
+		Validate:   core.Validator(),
 
 		// set later
 		Context: nil,
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// AccessTokenDenylist keeps track of access tokens that have been revoked before they expired.
+type AccessTokenDenylist interface {
+	// Deny rejects the access token with the given id until it expires.
+	Deny(c *Core, id string, expiresAt time.Time) error
+	// IsDenied reports whether the access token with the given id has been denied.
+	IsDenied(c *Core, id string) (bool, error)
+	// DenySubject rejects every access token of the given subject that was issued before the given time.
+	DenySubject(c *Core, subject string, before time.Time) error
+	// DeniedBefore returns the time before which access tokens of the given subject are rejected.
+	// It returns the zero time if none of the subject's access tokens have been denied.
+	DeniedBefore(c *Core, subject string) (time.Time, error)
+}
+
+// denylist is the denylist checked by StartSession.
+var denylist AccessTokenDenylist = NewMemoryAccessTokenDenylist()
+
+// memoryAccessTokenDenylist
+type memoryAccessTokenDenylist struct {
+	mu       sync.Mutex
+	ids      map[string]time.Time
+	subjects map[string]time.Time
+}
+
+// NewMemoryAccessTokenDenylist returns an AccessTokenDenylist that is kept in memory.
+// It's the default denylist, but it's lost on restart and isn't shared between instances.
+func NewMemoryAccessTokenDenylist() AccessTokenDenylist {
+	return &memoryAccessTokenDenylist{ids: map[string]time.Time{}, subjects: map[string]time.Time{}}
+}
+
+// Deny
+func (m *memoryAccessTokenDenylist) Deny(_ *Core, id string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// ids are only needed until their access token expires
+	now := time.Now()
+	for id, exp := range m.ids {
+		if exp.Before(now) {
+			delete(m.ids, id)
+		}
+	}
+
+	m.ids[id] = expiresAt
+	return nil
+}
+
+// IsDenied
+func (m *memoryAccessTokenDenylist) IsDenied(_ *Core, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.ids[id]
+	return ok, nil
+}
+
+// DenySubject
+func (m *memoryAccessTokenDenylist) DenySubject(_ *Core, subject string, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if before.After(m.subjects[subject]) {
+		m.subjects[subject] = before
+	}
+	return nil
+}
+
+// DeniedBefore
+func (m *memoryAccessTokenDenylist) DeniedBefore(_ *Core, subject string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.subjects[subject], nil
+}
+
+// RevokeAccessToken rejects the access token with the given id until it expires.
+func (c *Core) RevokeAccessToken(id string, expiresAt time.Time) error {
+	return denylist.Deny(c, id, expiresAt)
+}
+
+// RevokeSubject rejects every access token of the given subject that was issued before the given time, revokes all of
+// the subject's refresh tokens, and revokes the subject's API keys that were created before the given time. Use
+// time.Now() to log a user out everywhere, e.g. after a password change or when an account has been compromised. This
+// also revokes the subject's API keys, so their integrations stop working until they create new keys.
+//
+// The cutoff applies to access tokens and API keys. Access tokens are compared to the cutoff with sub-second precision,
+// so the subject can log in again right away. Every refresh token is revoked regardless of when it was issued, because
+// a refresh token issued after the cutoff may have been rotated from one issued before it.
+func (c *Core) RevokeSubject(subject string, before time.Time) error {
+	if err := denylist.DenySubject(c, subject, before); err != nil {
+		return err
+	}
+	if err := refreshTokens.RevokeSubject(c, subject); err != nil {
+		return err
+	}
+	if err := sessions.DeleteSubject(c, subject); err != nil {
+		return err
+	}
+	return c.revokeSubjectApiKeys(subject, before)
+}
+
+// checkDenylist returns an error if the given access token claims have been revoked.
+func checkDenylist(c *Core, claims *tokenClaims) error {
+	denied, err := denylist.IsDenied(c, claims.Id)
+	if err != nil {
+		return NewError(c, err)
+	}
+	if denied {
+		return NewError(c, KindRevokedAccessToken)
+	}
+
+	if claims.Sid != "" {
+		denied, err = denylist.IsDenied(c, deviceSessionDenylistId(claims.Sid))
+		if err != nil {
+			return NewError(c, err)
+		}
+		if denied {
+			return NewError(c, KindRevokedAccessToken)
+		}
+	}
+
+	before, err := denylist.DeniedBefore(c, claims.Subject)
+	if err != nil {
+		return NewError(c, err)
+	}
+	if claims.issuedAt().Before(before) {
+		return NewError(c, KindRevokedAccessToken)
+	}
+
+	// revoking the real subject also ends their impersonations
+	if claims.Imp != nil {
+		before, err = denylist.DeniedBefore(c, claims.Imp.Subject)
+		if err != nil {
+			return NewError(c, err)
+		}
+		if claims.issuedAt().Before(before) {
+			return NewError(c, KindRevokedAccessToken)
+		}
+	}
+
+	return nil
+}
+
+// deviceSessionDenylistId returns the id that denies every access token of the device session with the given id.
+// Access token ids never contain a colon, so it can't match one of them.
+func deviceSessionDenylistId(sid string) string {
+	return "sid:" + sid
+}
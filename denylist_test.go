@@ -0,0 +1,105 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDenylist_AccessToken(t *testing.T) {
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	denylist = NewMemoryAccessTokenDenylist()
+
+	c := newTestCore()
+	claims := generateToken(c, "subject", false).Claims.(*tokenClaims)
+	other := generateToken(c, "subject", false).Claims.(*tokenClaims)
+	require.NoError(t, checkDenylist(c, claims))
+
+	require.NoError(t, c.RevokeAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0)))
+	err := checkDenylist(c, claims)
+	require.Error(t, err)
+	require.Equal(t, KindRevokedAccessToken, err.(Error).Kind)
+
+	// only the revoked token is rejected
+	require.NoError(t, checkDenylist(c, other))
+}
+
+func TestCheckDenylist_Subject(t *testing.T) {
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	denylist = NewMemoryAccessTokenDenylist()
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	c := newTestCore()
+	cutoff := time.Now()
+	before := generateToken(c, "subject", false).Claims.(*tokenClaims)
+	setIssuedAt(before, cutoff.Add(-time.Minute))
+	after := generateToken(c, "subject", false).Claims.(*tokenClaims)
+	setIssuedAt(after, cutoff.Add(time.Minute))
+	other := generateToken(c, "other", false).Claims.(*tokenClaims)
+	setIssuedAt(other, cutoff.Add(-time.Minute))
+
+	_, record := newRefreshToken(c, "subject", "")
+	record.IssuedAt = cutoff.Add(time.Minute)
+	require.NoError(t, refreshTokens.Create(c, record))
+
+	require.NoError(t, c.RevokeSubject("subject", cutoff))
+	require.Error(t, checkDenylist(c, before))
+	require.NoError(t, checkDenylist(c, after))
+	require.NoError(t, checkDenylist(c, other))
+
+	// an earlier cutoff doesn't undo a later one
+	require.NoError(t, c.RevokeSubject("subject", cutoff.Add(-time.Hour)))
+	require.Error(t, checkDenylist(c, before))
+
+	// refresh tokens are revoked regardless of the cutoff
+	tokens, err := refreshTokens.ListSubject(c, "subject")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestCheckDenylist_SubjectSameSecond(t *testing.T) {
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	denylist = NewMemoryAccessTokenDenylist()
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	c := newTestCore()
+	cutoff := time.Unix(1600000000, 500_000_000)
+	before := generateToken(c, "subject", false).Claims.(*tokenClaims)
+	setIssuedAt(before, cutoff.Add(-200*time.Millisecond))
+	after := generateToken(c, "subject", false).Claims.(*tokenClaims)
+	setIssuedAt(after, cutoff.Add(200*time.Millisecond))
+
+	// tokens issued earlier in the same second are revoked, later ones aren't
+	require.NoError(t, c.RevokeSubject("subject", cutoff))
+	require.Error(t, checkDenylist(c, before))
+	require.NoError(t, checkDenylist(c, after))
+
+	// tokens without iat_ns are revoked if they were issued in the same second
+	after.IssuedAtNano = 0
+	require.Error(t, checkDenylist(c, after))
+}
+
+// setIssuedAt sets the "iat" and "iat_ns" claims of the token.
+func setIssuedAt(claims *tokenClaims, at time.Time) {
+	claims.IssuedAt = at.Unix()
+	claims.IssuedAtNano = at.UnixNano()
+}
+
+func TestMemoryAccessTokenDenylist_RemoveExpired(t *testing.T) {
+	c := newTestCore()
+	d := NewMemoryAccessTokenDenylist()
+
+	require.NoError(t, d.Deny(c, "expired", time.Now().Add(-time.Minute)))
+	require.NoError(t, d.Deny(c, "active", time.Now().Add(time.Minute)))
+
+	// the expired id was removed when the next one was denied, its access token is rejected because it expired
+	denied, err := d.IsDenied(c, "expired")
+	require.NoError(t, err)
+	require.False(t, denied)
+	denied, err = d.IsDenied(c, "active")
+	require.NoError(t, err)
+	require.True(t, denied)
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// DeviceSession is a login of the current subject on a device. Every device session is backed by a family of refresh
+// tokens, or by an opaque session when server.session.mode is "opaque", so it lasts until that expires or is revoked.
+type DeviceSession struct {
+	// Id identifies the device session. It's the family of its refresh tokens, or the key of its opaque session.
+	Id string
+	// Device is a short description of the device (e.g. "Firefox on Windows").
+	Device string
+	// Ip is the IP address the device was last seen from.
+	Ip string
+	// UserAgent is the user agent the device was last seen with.
+	UserAgent string
+	// LastSeenAt is when the device last refreshed its access token.
+	LastSeenAt time.Time
+	// ExpiresAt is when the device session ends, unless the device refreshes its access token before then.
+	ExpiresAt time.Time
+	// Current indicates whether this is the device session of the current request.
+	Current bool
+}
+
+// errDeviceSessionsNotSupported
+var errDeviceSessionsNotSupported = errors.New("device sessions are only supported with refresh tokens and opaque sessions")
+
+// Sessions returns the device sessions of the real subject, most recently seen first. While impersonating, these are
+// the device sessions of the subject doing the impersonating.
+func (s *session) Sessions() ([]DeviceSession, error) {
+	if s.IsAnonymous() {
+		return nil, NewError(s.core, KindUnauthorized)
+	}
+
+	tokens, err := refreshTokens.ListSubject(s.core, s.RealSubject())
+	if err != nil {
+		return nil, err
+	}
+
+	current := s.accessToken.Claims.(*tokenClaims).Sid
+	deviceSessions := make([]DeviceSession, len(tokens))
+	for i, token := range tokens {
+		deviceSessions[i] = DeviceSession{
+			Id:         token.Family,
+			Device:     token.Device,
+			Ip:         token.Ip,
+			UserAgent:  token.UserAgent,
+			LastSeenAt: token.IssuedAt,
+			ExpiresAt:  token.ExpiresAt,
+			Current:    token.Family == current,
+		}
+	}
+	return deviceSessions, nil
+}
+
+// RevokeSession revokes the device session with the given id. Its refresh tokens are revoked and its access tokens are
+// denied until the last one of them expires. If the device session is the current one, the current subject is logged
+// out.
+// Only device sessions of the real subject can be revoked, even while impersonating.
+func (s *session) RevokeSession(id string) error {
+	if s.IsAnonymous() {
+		return NewError(s.core, KindUnauthorized)
+	}
+
+	tokens, err := refreshTokens.ListSubject(s.core, s.RealSubject())
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.Family != id {
+			continue
+		}
+
+		if err = refreshTokens.RevokeFamily(s.core, id); err != nil {
+			return err
+		}
+		if err = denylist.Deny(s.core, deviceSessionDenylistId(id), time.Now().Add(maxAccessTokenTtl(s.core))); err != nil {
+			return err
+		}
+		s.core.Audit(AuditSessionRevoked, s.RealSubject(), map[string]interface{}{"family": id, "device": token.Device})
+		if id == s.accessToken.Claims.(*tokenClaims).Sid {
+			s.Revoke()
+		}
+		return nil
+	}
+
+	return NewError(s.core, KindRowNotFound)
+}
+
+// Sessions returns the stored sessions of the subject, most recently seen first.
+func (s *opaqueSession) Sessions() ([]DeviceSession, error) {
+	if s.IsAnonymous() {
+		return nil, NewError(s.core, KindUnauthorized)
+	}
+
+	stored, err := sessions.ListSubject(s.core, s.stored.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceSessions := make([]DeviceSession, len(stored))
+	for i, session := range stored {
+		deviceSessions[i] = DeviceSession{
+			Id:         session.Key,
+			Device:     session.Device,
+			Ip:         session.Ip,
+			UserAgent:  session.UserAgent,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.Key == s.stored.Key,
+		}
+	}
+	return deviceSessions, nil
+}
+
+// RevokeSession deletes the stored session with the given key. If it's the current session, the subject is logged out.
+func (s *opaqueSession) RevokeSession(id string) error {
+	if s.IsAnonymous() {
+		return NewError(s.core, KindUnauthorized)
+	}
+
+	stored, err := sessions.ListSubject(s.core, s.stored.Subject)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range stored {
+		if session.Key != id {
+			continue
+		}
+
+		if err = sessions.Delete(s.core, id); err != nil {
+			return err
+		}
+		s.core.Audit(AuditSessionRevoked, session.Subject, map[string]interface{}{"session": id, "device": session.Device})
+		if id == s.stored.Key {
+			setSessionId(s.core, "")
+			*s = opaqueSession{core: s.core}
+		}
+		return nil
+	}
+
+	return NewError(s.core, KindRowNotFound)
+}
+
+// Sessions always returns an error, API keys aren't backed by refresh tokens.
+func (s *apiKeySession) Sessions() ([]DeviceSession, error) {
+	return nil, NewError(s.core, errDeviceSessionsNotSupported)
+}
+
+// RevokeSession always returns an error, API keys aren't backed by refresh tokens.
+func (s *apiKeySession) RevokeSession(string) error {
+	return NewError(s.core, errDeviceSessionsNotSupported)
+}
+
+// deviceName returns a short description of the device from its user agent, e.g. "Firefox on Windows".
+func deviceName(userAgent string) string {
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+// maxAccessTokenTtl returns how long the longest lived access token of a device session can be valid for.
+func maxAccessTokenTtl(c *Core) time.Duration {
+	ttl := c.Config.CoreConfig().Server.Jwt.AccessToken.ExpiresAt
+	if cfg := c.Config.CoreConfig().Server.Impersonation; cfg != nil && cfg.ExpiresAt > ttl {
+		ttl = cfg.ExpiresAt
+	}
+	return ttl
+}
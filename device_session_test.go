@@ -0,0 +1,80 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newDeviceSessionTestCore(userAgent string) *Core {
+	c := newTestCore()
+	c.Request.Header.Set("User-Agent", userAgent)
+	return c
+}
+
+func TestSession_Sessions(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	refreshTokens = NewMemoryRefreshTokenStore()
+	denylist = NewMemoryAccessTokenDenylist()
+
+	laptop := newDeviceSessionTestCore("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:79.0) Gecko/20100101 Firefox/79.0")
+	laptop.Session.LoginSubject("subject")
+	phone := newDeviceSessionTestCore("Mozilla/5.0 (iPhone; CPU iPhone OS 13_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.2 Mobile/15E148 Safari/604.1")
+	phone.Session.LoginSubject("subject")
+
+	deviceSessions, err := laptop.Session.Sessions()
+	require.NoError(t, err)
+	require.Len(t, deviceSessions, 2)
+
+	devices := map[string]bool{}
+	var phoneId string
+	for _, deviceSession := range deviceSessions {
+		devices[deviceSession.Device] = deviceSession.Current
+		if !deviceSession.Current {
+			phoneId = deviceSession.Id
+		}
+	}
+	require.Equal(t, map[string]bool{"Firefox on Windows": true, "Safari on iOS": false}, devices)
+
+	require.NoError(t, laptop.Session.RevokeSession(phoneId))
+	deviceSessions, err = laptop.Session.Sessions()
+	require.NoError(t, err)
+	require.Len(t, deviceSessions, 1)
+	require.True(t, deviceSessions[0].Current)
+
+	// sessions of other subjects can't be revoked
+	other := newDeviceSessionTestCore("")
+	other.Session.LoginSubject("other")
+	require.Error(t, other.Session.RevokeSession(deviceSessions[0].Id))
+
+	// revoking the current session logs out
+	require.NoError(t, laptop.Session.RevokeSession(deviceSessions[0].Id))
+	require.True(t, laptop.Session.IsAnonymous())
+}
+
+func TestSession_RevokeSession_AccessToken(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	refreshTokens = NewMemoryRefreshTokenStore()
+	denylist = NewMemoryAccessTokenDenylist()
+
+	laptop := newDeviceSessionTestCore("")
+	laptop.Session.LoginSubject("subject")
+	phone := newDeviceSessionTestCore("")
+	phone.Session.LoginSubject("subject")
+	phoneId := phone.Session.(*session).accessToken.Claims.(*tokenClaims).Sid
+
+	// the revoked device's access token is rejected right away, not only when it tries to refresh
+	require.NoError(t, laptop.Session.RevokeSession(phoneId))
+	c := newDeviceSessionTestCore("")
+	c.Request.Header.Set("Authorization", "Bearer "+phone.Session.AccessToken())
+	err := c.StartSession()
+	require.Error(t, err)
+	require.Equal(t, KindRevokedAccessToken, err.(Error).Kind)
+
+	c = newDeviceSessionTestCore("")
+	c.Request.Header.Set("Authorization", "Bearer "+laptop.Session.AccessToken())
+	require.NoError(t, c.StartSession())
+	require.True(t, c.Session.IsLoggedIn())
+}
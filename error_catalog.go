@@ -0,0 +1,136 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidErrorKindCode is returned when an ErrorKind's code doesn't start with a valid HTTP status.
+	ErrInvalidErrorKindCode = errors.New("the first 3 digits of an error kind's code must be a valid HTTP status")
+	// ErrErrorKindCollision is returned when a different ErrorKind has already been registered with the same code.
+	ErrErrorKindCollision = errors.New("an error kind with the same code has already been registered")
+)
+
+// errorKinds contains every registered ErrorKind, keyed by code.
+var errorKinds = map[int]ErrorKind{}
+
+func init() {
+	err := RegisterErrorKinds(
+		KindInvalidJson, KindStructValidation, KindInvalidContentType, KindInvalidTokenExchange, KindConstraintViolation,
+		KindUnauthorized, KindInvalidCredentials, KindInvalidJwt, KindExpiredAccessToken, KindRevokedAccessToken,
+		KindInvalidSession, KindInvalidApiKey, KindOidc, KindInvalidServiceCredentials, KindSecondFactorRequired,
+		KindInvalidSecondFactor, KindInvalidSignedToken,
+		KindInsufficientScope, KindImpersonationForbidden,
+		KindRouteNotFound, KindRowNotFound,
+		KindMethodNotAllowed,
+		KindConflict,
+		KindInvalidReference,
+		KindAccountLocked,
+		KindUnknown, KindDatabase,
+		KindDatabaseTimeout, KindTransactionConflict,
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RegisterErrorKinds adds the kinds to the error catalog. It returns an error if a kind's code doesn't start with a
+// valid HTTP status, or if a different kind has already been registered with the same code. Registering the same kind
+// twice is allowed.
+//
+// Kinds given to core.Options.ErrorKinds are registered when the server starts.
+func RegisterErrorKinds(kinds ...ErrorKind) error {
+	for _, kind := range kinds {
+		status := kind.Code / 1000
+		if kind.Code < 100_000 || kind.Code > 999_999 || http.StatusText(status) == "" {
+			return fmt.Errorf("%w: %d (%s)", ErrInvalidErrorKindCode, kind.Code, kind.Title)
+		}
+
+		if existing, ok := errorKinds[kind.Code]; ok && existing != kind {
+			return fmt.Errorf("%w: %d (%s) collides with %d (%s)", ErrErrorKindCollision, kind.Code, kind.Title, existing.Code, existing.Title)
+		}
+		errorKinds[kind.Code] = kind
+	}
+	return nil
+}
+
+// ErrorKinds returns every registered ErrorKind, ordered by code.
+func ErrorKinds() []ErrorKind {
+	kinds := make([]ErrorKind, 0, len(errorKinds))
+	for _, kind := range errorKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Code < kinds[j].Code })
+	return kinds
+}
+
+// ErrorCatalogEntry describes a registered ErrorKind for clients.
+type ErrorCatalogEntry struct {
+	Code       int    `json:"code"`
+	HttpStatus int    `json:"httpStatus"`
+	Title      string `json:"title"`
+	Message    string `json:"message"`
+	Retryable  bool   `json:"retryable"`
+}
+
+// ErrorCatalog returns an entry for every registered ErrorKind, ordered by code.
+func ErrorCatalog() []ErrorCatalogEntry {
+	kinds := ErrorKinds()
+	entries := make([]ErrorCatalogEntry, len(kinds))
+	for i, kind := range kinds {
+		entries[i] = ErrorCatalogEntry{
+			Code:       kind.Code,
+			HttpStatus: kind.Code / 1000,
+			Title:      kind.Title,
+			Message:    kind.Message,
+			Retryable:  kind.Retryable,
+		}
+	}
+	return entries
+}
+
+// ErrorCatalogJson returns the error catalog as a JSON array.
+func ErrorCatalogJson() ([]byte, error) {
+	return json.MarshalIndent(ErrorCatalog(), "", "  ")
+}
+
+// ErrorCatalogMarkdown returns the error catalog as a Markdown table.
+func ErrorCatalogMarkdown() string {
+	escape := strings.NewReplacer("|", "\\|", "\n", " ").Replace
+
+	var b strings.Builder
+	b.WriteString("| Code | HTTP Status | Title | Message | Retryable |\n")
+	b.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, entry := range ErrorCatalog() {
+		b.WriteString("| " + strconv.Itoa(entry.Code) +
+			" | " + strconv.Itoa(entry.HttpStatus) +
+			" | " + escape(entry.Title) +
+			" | " + escape(entry.Message) +
+			" | " + strconv.FormatBool(entry.Retryable) + " |\n")
+	}
+	return b.String()
+}
+
+// serveErrorCatalog writes the error catalog as JSON, or as Markdown if the format query parameter is "markdown" or
+// the client accepts text/markdown.
+func serveErrorCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("format") == "markdown" || strings.Contains(r.Header.Get("Accept"), "text/markdown") {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		_, _ = w.Write([]byte(ErrorCatalogMarkdown()))
+		return
+	}
+
+	catalog, err := ErrorCatalogJson()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(catalog)
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRegisterErrorKinds(t *testing.T) {
+	defer func(kinds map[int]ErrorKind) { errorKinds = kinds }(errorKinds)
+	errorKinds = map[int]ErrorKind{}
+	for _, kind := range []ErrorKind{KindInvalidJson, KindRowNotFound} {
+		errorKinds[kind.Code] = kind
+	}
+
+	kindTodoDone := ErrorKind{Code: 409_100, Title: "Todo Done", Message: "The todo is already done", Severity: zapcore.InfoLevel}
+	require.NoError(t, RegisterErrorKinds(kindTodoDone))
+	// registering the same kind again is fine
+	require.NoError(t, RegisterErrorKinds(kindTodoDone))
+
+	err := RegisterErrorKinds(ErrorKind{Code: 400_000, Title: "Bad Todo"})
+	require.True(t, errors.Is(err, ErrErrorKindCollision))
+	require.Contains(t, err.Error(), "Invalid JSON")
+
+	for _, code := range []int{499_000, 600_000, 40_000, 1_000_000} {
+		err = RegisterErrorKinds(ErrorKind{Code: code, Title: "Invalid"})
+		require.True(t, errors.Is(err, ErrInvalidErrorKindCode), code)
+	}
+
+	require.Equal(t, []ErrorKind{KindInvalidJson, KindRowNotFound, kindTodoDone}, ErrorKinds())
+}
+
+func TestErrorCatalog(t *testing.T) {
+	defer func(kinds map[int]ErrorKind) { errorKinds = kinds }(errorKinds)
+	errorKinds = map[int]ErrorKind{}
+	require.NoError(t, RegisterErrorKinds(
+		KindRowNotFound,
+		KindAccountLocked,
+		ErrorKind{Code: 409_100, Title: "Todo Done", Message: "The todo is already done | or deleted"},
+	))
+
+	var entries []ErrorCatalogEntry
+	rec := httptest.NewRecorder()
+	serveErrorCatalog(rec, httptest.NewRequest("GET", "/errors", nil))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	require.Equal(t, []ErrorCatalogEntry{
+		{Code: 404_001, HttpStatus: 404, Title: "Not Found", Message: "The requested resource was not found"},
+		{Code: 409_100, HttpStatus: 409, Title: "Todo Done", Message: "The todo is already done | or deleted"},
+		{Code: 423_000, HttpStatus: 423, Title: "Account Locked", Message: "Too many failed login attempts, please try again", Retryable: true},
+	}, entries)
+
+	rec = httptest.NewRecorder()
+	serveErrorCatalog(rec, httptest.NewRequest("GET", "/errors?format=markdown", nil))
+	require.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/markdown"))
+	require.Equal(t, "| Code | HTTP Status | Title | Message | Retryable |\n"+
+		"| --- | --- | --- | --- | --- |\n"+
+		"| 404001 | 404 | Not Found | The requested resource was not found | false |\n"+
+		"| 409100 | 409 | Todo Done | The todo is already done \\| or deleted | false |\n"+
+		"| 423000 | 423 | Account Locked | Too many failed login attempts, please try again | true |\n", rec.Body.String())
+}
+
+func TestErrorKinds_Builtin(t *testing.T) {
+	// every built-in kind is shown in the catalog and reported with a title and message
+	for _, kind := range ErrorKinds() {
+		require.NotEmpty(t, kind.Title, kind.Code)
+		require.NotEmpty(t, kind.Message, kind.Code)
+	}
+}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// StackFrame is a function call within a stack trace.
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// captureStack returns the stack trace of the calling goroutine, starting skip frames above the caller of captureStack.
+func captureStack(skip int) []StackFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []StackFrame
+	for {
+		frame, more := frames.Next()
+		stack = append(stack, StackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// formatStack formats the stack trace the way Go prints the stack of a goroutine, which is what error reporting
+// services expect.
+func formatStack(stack []StackFrame) string {
+	var b strings.Builder
+	b.WriteString("goroutine 1 [running]:\n")
+	for _, frame := range stack {
+		b.WriteString(frame.Function + "(...)\n\t" + frame.File + ":" + strconv.Itoa(frame.Line) + "\n")
+	}
+	return b.String()
+}
+
+// ErrorReport is an error that's sent to an ErrorReporter.
+type ErrorReport struct {
+	// Error is the error that occurred.
+	Error Error
+	// Time is when the error occurred.
+	Time time.Time
+	// Context is the context of the request. Reports are sent in the background, so it's usually canceled by then.
+	Context context.Context
+	// Request is the request the error occurred in.
+	Request *http.Request
+	// RequestId is the id of the request (*core.Core.Id).
+	RequestId string
+	// Subject is the subject that made the request. It's empty if the request was anonymous.
+	Subject string
+	// Operations are the operations the request went through (*core.Core.Operations).
+	Operations []string
+	// Stack is where the error occurred, most recent call first.
+	Stack []StackFrame
+}
+
+// ErrorReporter sends errors to an error tracking service. Errors are reported if their severity is error or higher.
+// Reports are sent one at a time in the background, and are dropped if too many are waiting to be sent.
+type ErrorReporter interface {
+	// Report sends the error to the error tracking service.
+	Report(c *Core, report *ErrorReport) error
+}
+
+// errorReportQueueSize is how many reports can wait to be sent before new ones are dropped.
+const errorReportQueueSize = 100
+
+// errorReports queues every error with a severity of error or higher for the ErrorReporter.
+var errorReports *errorReportQueue
+
+// queuedErrorReport is a report waiting to be sent, along with the *core.Core of the request it came from, which is
+// given to ErrorReporter.Report.
+type queuedErrorReport struct {
+	core   *Core
+	report *ErrorReport
+}
+
+// errorReportQueue sends reports to an ErrorReporter in the background, so requests don't wait for the error
+// tracking service.
+type errorReportQueue struct {
+	reporter ErrorReporter
+	reports  chan queuedErrorReport
+	// sent is closed once the queue is shut down and every queued report has been sent
+	sent chan struct{}
+
+	// mu guards closed, so reports are never queued after reports is closed
+	mu     sync.Mutex
+	closed bool
+}
+
+// newErrorReportQueue returns a queue that holds up to size reports and starts sending them to the reporter.
+func newErrorReportQueue(reporter ErrorReporter, size int) *errorReportQueue {
+	q := &errorReportQueue{reporter: reporter, reports: make(chan queuedErrorReport, size), sent: make(chan struct{})}
+	go q.send()
+	return q
+}
+
+// send reports the queued errors one at a time until the queue is shut down. Failing to report an error is logged.
+func (q *errorReportQueue) send() {
+	defer close(q.sent)
+	for r := range q.reports {
+		if err := q.reporter.Report(r.core, r.report); err != nil {
+			r.core.Logger.Warn("failed to report error", "error", err, "code", r.report.Error.Kind.Code)
+		}
+	}
+}
+
+// enqueue queues the report. If the queue is full, or has been shut down, the report is dropped and logged instead, so
+// a slow error tracking service can't slow down requests.
+func (q *errorReportQueue) enqueue(c *Core, report *ErrorReport) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		c.Logger.Warn("dropped error report, the error report queue has been shut down", "code", report.Error.Kind.Code)
+		return
+	}
+
+	select {
+	case q.reports <- queuedErrorReport{core: c, report: report}:
+	default:
+		c.Logger.Warn("dropped error report, the error report queue is full", "code", report.Error.Kind.Code)
+	}
+}
+
+// shutdown stops queueing reports and waits until the queued ones are sent, or until the timeout passes. It returns
+// false if reports were still being sent when the timeout passed. It can be called more than once.
+func (q *errorReportQueue) shutdown(timeout time.Duration) bool {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.reports)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.sent:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// reportError queues the error to be sent to the ErrorReporter. Failing to report an error is logged, but doesn't
+// fail the request.
+func reportError(e *Error) {
+	if errorReports == nil || e.Kind.Severity < zapcore.ErrorLevel {
+		return
+	}
+
+	report := &ErrorReport{
+		Error:      *e,
+		Time:       time.Now(),
+		Context:    e.core.Context,
+		Request:    e.core.Request,
+		RequestId:  e.core.Id,
+		Operations: append([]string(nil), e.core.Operations...),
+		Stack:      e.stack,
+	}
+	if e.core.Session != nil {
+		report.Subject = e.core.Session.Subject()
+	}
+
+	errorReports.enqueue(e.core, report)
+}
+
+// googleErrorReportingEntry returns the message and fields of a log entry that Google Cloud Error Reporting picks up.
+// https://cloud.google.com/error-reporting/docs/formatting-error-messages
+func googleErrorReportingEntry(e *Error, cfg *ErrorReportingConfig) (string, []interface{}) {
+	message := e.Kind.Title + ": " + e.Error()
+	if len(e.stack) > 0 {
+		message += "\n\n" + formatStack(e.stack)
+	}
+
+	fields := []interface{}{
+		"@type", "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",
+		"serviceContext", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("service", cfg.Service)
+			enc.AddString("version", cfg.Version)
+			return nil
+		}),
+		"context", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			if r := e.core.Request; r != nil {
+				_ = enc.AddObject("httpRequest", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("method", r.Method)
+					enc.AddString("url", r.URL.String())
+					enc.AddString("userAgent", r.UserAgent())
+					enc.AddString("referrer", r.Referer())
+					enc.AddString("remoteIp", e.core.remoteIp())
+					return nil
+				}))
+			}
+			if e.core.Session != nil && e.core.Session.Subject() != "" {
+				enc.AddString("user", e.core.Session.Subject())
+			}
+			if len(e.stack) > 0 {
+				_ = enc.AddObject("reportLocation", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+					enc.AddString("filePath", e.stack[0].File)
+					enc.AddInt("lineNumber", e.stack[0].Line)
+					enc.AddString("functionName", e.stack[0].Function)
+					return nil
+				}))
+			}
+			return nil
+		}),
+	}
+	return message, fields
+}
+
+// sentryReporter
+type sentryReporter struct {
+	dsn      string
+	key      string
+	endpoint string
+	client   *http.Client
+}
+
+// NewSentryReporter returns an ErrorReporter that sends errors to Sentry (or anything that accepts Sentry envelopes)
+// with the given DSN, e.g. https://public_key@o0.ingest.sentry.io/0.
+func NewSentryReporter(dsn string) (ErrorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sentry dsn %s doesn't contain a public key", dsn)
+	}
+
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndexByte(path, '/')
+	prefix, projectId := path[:i+1], path[i+1:]
+	if projectId == "" {
+		return nil, fmt.Errorf("sentry dsn %s doesn't contain a project id", dsn)
+	}
+
+	return &sentryReporter{
+		dsn:      dsn,
+		key:      u.User.Username(),
+		endpoint: u.Scheme + "://" + u.Host + "/" + prefix + "api/" + projectId + "/envelope/",
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+// Report
+func (s *sentryReporter) Report(c *Core, report *ErrorReport) error {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return err
+	}
+	eventId := hex.EncodeToString(id)
+
+	event, err := json.Marshal(sentryEvent(c, eventId, report))
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]interface{}{"event_id": eventId, "sent_at": time.Now().UTC().Format(time.RFC3339), "dsn": s.dsn})
+	if err != nil {
+		return err
+	}
+	item, err := json.Marshal(map[string]interface{}{"type": "event", "length": len(event), "content_type": "application/json"})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, event} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest("POST", s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=scott-rc-core/1.0, sentry_key="+s.key)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sentry responded with %s", res.Status)
+	}
+	return nil
+}
+
+// sentryEvent returns the report as a Sentry event.
+// https://develop.sentry.dev/sdk/event-payloads/
+func sentryEvent(c *Core, eventId string, report *ErrorReport) map[string]interface{} {
+	// sentry expects the most recent call last
+	frames := make([]map[string]interface{}, len(report.Stack))
+	for i, frame := range report.Stack {
+		frames[len(frames)-1-i] = map[string]interface{}{
+			"function": frame.Function,
+			"abs_path": frame.File,
+			"lineno":   frame.Line,
+		}
+	}
+
+	level := "error"
+	if report.Error.Kind.Severity > zapcore.ErrorLevel {
+		level = "fatal"
+	}
+
+	cause := fmt.Sprintf("%T", report.Error)
+	if report.Error.Cause != nil {
+		cause = fmt.Sprintf("%T", report.Error.Cause)
+	}
+
+	event := map[string]interface{}{
+		"event_id":  eventId,
+		"timestamp": report.Time.UTC().Format(time.RFC3339Nano),
+		"platform":  "go",
+		"level":     level,
+		"logger":    "core",
+		"message":   map[string]interface{}{"formatted": report.Error.Kind.Title + ": " + report.Error.Error()},
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{
+				"type":       cause,
+				"value":      report.Error.Error(),
+				"stacktrace": map[string]interface{}{"frames": frames},
+			}},
+		},
+		"tags": map[string]string{
+			"request_id": report.RequestId,
+			"code":       strconv.Itoa(report.Error.Kind.Code),
+		},
+		"extra": map[string]interface{}{
+			"operations": report.Operations,
+			"details":    report.Error.Details,
+		},
+	}
+	if c.Config != nil {
+		event["environment"] = c.Config.CoreConfig().Env
+	}
+	if report.Subject != "" {
+		event["user"] = map[string]interface{}{"id": report.Subject}
+	}
+	if r := report.Request; r != nil {
+		event["request"] = map[string]interface{}{
+			"method":  r.Method,
+			"url":     r.URL.String(),
+			"headers": map[string]string{"User-Agent": r.UserAgent(), "Referer": r.Referer()},
+		}
+	}
+	return event
+}
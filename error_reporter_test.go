@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryErrorReporter
+type memoryErrorReporter struct {
+	reports []*ErrorReport
+}
+
+// Report
+func (m *memoryErrorReporter) Report(_ *Core, report *ErrorReport) error {
+	m.reports = append(m.reports, report)
+	return nil
+}
+
+func newErrorReporterTestCore() *Core {
+	c := newTestCore()
+	c.Id = "request"
+	c.Operations = []string{"server.Post", "resolver.TodoCreate"}
+	return c
+}
+
+func TestReportError(t *testing.T) {
+	defer func(q *errorReportQueue) { errorReports = q }(errorReports)
+	reporter := &memoryErrorReporter{}
+	errorReports = newErrorReportQueue(reporter, errorReportQueueSize)
+
+	c := newErrorReporterTestCore()
+	c.Session.LoginSubject("subject")
+
+	// errors below the error severity aren't reported
+	_ = NewError(c, KindRowNotFound)
+	e := NewError(c, KindDatabase)
+	// errors are only reported once
+	_ = NewError(c, e)
+
+	require.True(t, errorReports.shutdown(time.Second))
+	require.Len(t, reporter.reports, 1)
+	report := reporter.reports[0]
+	require.Equal(t, KindDatabase, report.Error.Kind)
+	require.Equal(t, "request", report.RequestId)
+	require.Equal(t, "subject", report.Subject)
+	require.Equal(t, []string{"server.Post", "resolver.TodoCreate"}, report.Operations)
+	require.Same(t, c.Request, report.Request)
+	require.Contains(t, report.Stack[0].Function, "TestReportError")
+}
+
+// blockingErrorReporter
+type blockingErrorReporter struct {
+	memoryErrorReporter
+	started chan struct{}
+	unblock chan struct{}
+}
+
+// Report
+func (b *blockingErrorReporter) Report(c *Core, report *ErrorReport) error {
+	b.started <- struct{}{}
+	<-b.unblock
+	return b.memoryErrorReporter.Report(c, report)
+}
+
+func TestReportError_Queue(t *testing.T) {
+	defer func(q *errorReportQueue) { errorReports = q }(errorReports)
+	reporter := &blockingErrorReporter{started: make(chan struct{}, 3), unblock: make(chan struct{})}
+	errorReports = newErrorReportQueue(reporter, 1)
+
+	// reporting doesn't wait for the error tracking service
+	c := newErrorReporterTestCore()
+	_ = NewError(c, KindDatabase, "first")
+	<-reporter.started
+	_ = NewError(c, KindDatabase, "second")
+	// the queue is full, so this one is dropped
+	_ = NewError(c, KindDatabase, "third")
+	require.False(t, errorReports.shutdown(10*time.Millisecond))
+	// the queue has been shut down, so this one is dropped too
+	_ = NewError(c, KindDatabase, "fourth")
+
+	close(reporter.unblock)
+	require.True(t, errorReports.shutdown(time.Second))
+	require.Len(t, reporter.reports, 2)
+	require.Equal(t, "first", reporter.reports[0].Error.Error())
+	require.Equal(t, "second", reporter.reports[1].Error.Error())
+}
+
+func TestSentryReporter(t *testing.T) {
+	var auth string
+	var envelope [][]byte
+	sentry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prefix/api/42/envelope/", r.URL.Path)
+		auth = r.Header.Get("X-Sentry-Auth")
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			envelope = append(envelope, append([]byte{}, scanner.Bytes()...))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer sentry.Close()
+
+	_, err := NewSentryReporter("http://localhost/42")
+	require.Error(t, err)
+	_, err = NewSentryReporter("http://public@localhost/")
+	require.Error(t, err)
+
+	dsn := strings.Replace(sentry.URL, "http://", "http://public@", 1) + "/prefix/42"
+	reporter, err := NewSentryReporter(dsn)
+	require.NoError(t, err)
+
+	defer func(q *errorReportQueue) { errorReports = q }(errorReports)
+	errorReports = newErrorReportQueue(reporter, errorReportQueueSize)
+
+	c := newErrorReporterTestCore()
+	c.Session.LoginSubject("subject")
+	_ = NewError(c, KindDatabase, "failed to insert todo")
+	require.True(t, errorReports.shutdown(time.Second))
+
+	require.Contains(t, auth, "sentry_key=public")
+	require.Len(t, envelope, 3)
+
+	var header, item, event map[string]interface{}
+	require.NoError(t, json.Unmarshal(envelope[0], &header))
+	require.NoError(t, json.Unmarshal(envelope[1], &item))
+	require.NoError(t, json.Unmarshal(envelope[2], &event))
+
+	require.Equal(t, dsn, header["dsn"])
+	require.Equal(t, header["event_id"], event["event_id"])
+	require.Len(t, event["event_id"], 32)
+	require.Equal(t, "event", item["type"])
+	require.Equal(t, float64(len(envelope[2])), item["length"])
+
+	require.Equal(t, "error", event["level"])
+	require.Equal(t, EnvProduction, event["environment"])
+	require.Equal(t, map[string]interface{}{"id": "subject"}, event["user"])
+	require.Equal(t, map[string]interface{}{"request_id": "request", "code": "500001"}, event["tags"])
+	require.Equal(t, "failed to insert todo", event["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})["value"])
+
+	// sentry expects the most recent call last
+	frames := event["exception"].(map[string]interface{})["values"].([]interface{})[0].(map[string]interface{})["stacktrace"].(map[string]interface{})["frames"].([]interface{})
+	require.Contains(t, frames[len(frames)-1].(map[string]interface{})["function"], "TestSentryReporter")
+
+	// failing to report is logged, but doesn't panic
+	sentry.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	err = reporter.Report(c, &ErrorReport{Error: NewError(c, KindUnknown)})
+	require.Error(t, err)
+}
+
+func TestGoogleErrorReportingEntry(t *testing.T) {
+	c := newErrorReporterTestCore()
+	c.Session.LoginSubject("subject")
+	e := NewError(c, KindDatabase, "failed to insert todo")
+
+	message, fields := googleErrorReportingEntry(&e, &ErrorReportingConfig{Service: "api", Version: "1"})
+	require.True(t, strings.HasPrefix(message, "Database Error: failed to insert todo\n\ngoroutine 1 [running]:\n"))
+	require.Contains(t, message, "TestGoogleErrorReportingEntry(...)\n\t")
+	require.Equal(t, "@type", fields[0])
+	require.Equal(t, "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent", fields[1])
+}
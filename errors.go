@@ -1,17 +1,26 @@
 package core
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	entranslations "github.com/go-playground/validator/v10/translations/en"
+	"github.com/lib/pq"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -24,10 +33,17 @@ var (
 func init() {
 	eng := en.New()
 	uni = ut.New(eng, eng)
-	validate = validator.New()
+	validate = newValidator()
 	_ = entranslations.RegisterDefaultTranslations(validate, uni.GetFallback())
 }
 
+// newValidator returns a validator that names fields after their GraphQL input fields.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(graphqlFieldName)
+	return v
+}
+
 // ErrorDetailer is a function that takes a *core.Error so that it can add details to it.
 type ErrorDetailer func(*Error)
 
@@ -39,27 +55,170 @@ func DefaultErrorDecorator(e *Error) {
 		}
 	}
 
-	switch err := e.Cause.(type) {
-	case validator.ValidationErrors:
+	// transient causes can succeed if the request is sent again
+	if isTransient(e.Cause) {
+		e.Retryable = true
+	}
+
+	// the cause may have been wrapped (e.g. with fmt.Errorf("...: %w", err)), so every check looks through the chain
+	var validationErrs validator.ValidationErrors
+	var jwtErr *jwt.ValidationError
+	var pqErr *pq.Error
+	var stateErr sqlStateError
+	switch {
+	case errors.As(e.Cause, &validationErrs):
 		changeTo(KindStructValidation)
-		for _, err := range err {
-			e.Details = append(e.Details, err.Translate(uni.GetFallback()))
+		for _, err := range validationErrs {
+			message := err.Translate(e.core.translator())
+			e.Details = append(e.Details, message)
+			e.Fields = append(e.Fields, FieldError{
+				Path:    graphqlPath(err.Namespace(), err.StructNamespace()),
+				Rule:    err.Tag(),
+				Param:   err.Param(),
+				Message: message,
+			})
 		}
-	case *jwt.ValidationError:
-		if err.Errors == jwt.ValidationErrorExpired {
+	case errors.As(e.Cause, &jwtErr):
+		if jwtErr.Errors == jwt.ValidationErrorExpired {
 			changeTo(KindExpiredAccessToken)
 		} else {
 			changeTo(KindInvalidJwt)
 		}
-		e.Details = append(e.Details, err.Error())
+		e.Details = append(e.Details, jwtErr.Error())
+	case errors.As(e.Cause, &pqErr):
+		kind := sqlStateKind(string(pqErr.Code))
+		changeTo(kind)
+		e.Retryable = e.Retryable || kind.Retryable
+		e.Details = append(e.Details, postgresDetails(pqErr)...)
+	case errors.As(e.Cause, &stateErr):
+		kind := sqlStateKind(stateErr.SQLState())
+		changeTo(kind)
+		e.Retryable = e.Retryable || kind.Retryable
+	case errors.Is(e.Cause, sql.ErrNoRows):
+		changeTo(KindRowNotFound)
+	case e.Cause != nil && strings.Contains(e.Cause.Error(), "models"):
+		changeTo(KindDatabase)
+	}
+}
+
+// isTransient reports whether the error is likely to go away if the request is sent again, e.g. timeouts and broken
+// database connections.
+func isTransient(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// graphqlFieldName names struct fields after their graphql or json tag when validating, so a field's namespace
+// contains the name of its GraphQL input field. Fields without either tag keep their Go name.
+func graphqlFieldName(field reflect.StructField) string {
+	for _, key := range []string{"graphql", "json"} {
+		if name := strings.Split(field.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return ""
+}
+
+// graphqlPath converts the namespace of a struct field to the path of its GraphQL input field, e.g.
+// "Todo.Items[0].CompletedAt" becomes "todo.items.0.completedAt".
+//
+// Segments named by a graphql or json tag (see graphqlFieldName) are used as is, they differ from the segment of the
+// struct namespace. Other segments are Go field names and are converted with graphqlName.
+//
+// The namespace of a field within an anonymous struct (e.g. a resolver's args) starts with the field's name, so
+// validating a resolver's args gives paths that start with the argument's name. When a named struct is validated, the
+// namespace starts with the struct's name instead.
+func graphqlPath(namespace, structNamespace string) string {
+	structSegments := strings.Split(structNamespace, ".")
+
+	var path []string
+	for i, segment := range strings.Split(namespace, ".") {
+		// slice and map fields end with their index or key, e.g. Items[0]
+		segment, keys := splitNamespaceKeys(segment)
+		if i < len(structSegments) {
+			if structSegment, _ := splitNamespaceKeys(structSegments[i]); structSegment == segment {
+				segment = graphqlName(segment)
+			}
+		}
+		path = append(path, segment)
+		path = append(path, keys...)
+	}
+	return strings.Join(path, ".")
+}
+
+// splitNamespaceKeys splits a segment of a namespace into the field's name and its indexes or keys, e.g. "Items[0]"
+// becomes "Items" and ["0"].
+func splitNamespaceKeys(segment string) (string, []string) {
+	i := strings.IndexByte(segment, '[')
+	if i == -1 {
+		return segment, nil
+	}
+	return segment[:i], strings.Split(strings.TrimSuffix(segment[i+1:], "]"), "][")
+}
+
+// graphqlName converts the name of a struct field to the name of its GraphQL field, e.g. "CompletedAt" becomes
+// "completedAt" and "URLPath" becomes "urlPath".
+func graphqlName(name string) string {
+	runes := []rune(name)
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	if upper > 1 && upper < len(runes) {
+		// the last upper case letter starts the next word
+		upper--
+	}
+	for i := 0; i < upper; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// sqlStateError is implemented by drivers that expose the SQLSTATE of an error (e.g. pgx's *pgconn.PgError).
+type sqlStateError interface {
+	error
+	SQLState() string
+}
+
+// sqlStateKind returns the kind of error that the SQLSTATE code represents.
+// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+func sqlStateKind(code string) ErrorKind {
+	switch code {
+	case "23505": // unique_violation
+		return KindConflict
+	case "23503": // foreign_key_violation
+		return KindInvalidReference
+	case "23502", "23514", "22001": // not_null_violation, check_violation, string_data_right_truncation
+		return KindConstraintViolation
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return KindTransactionConflict
+	case "57014": // query_canceled, which includes statement_timeout
+		return KindDatabaseTimeout
 	default:
-		switch {
-		case e.Cause == sql.ErrNoRows:
-			changeTo(KindRowNotFound)
-		case strings.Contains(e.Cause.Error(), "models"):
-			changeTo(KindDatabase)
+		return KindDatabase
+	}
+}
+
+// postgresDetails returns the constraint and column that caused the error.
+func postgresDetails(err *pq.Error) []string {
+	var details []string
+	if err.Constraint != "" {
+		details = append(details, "constraint: "+err.Constraint)
+	}
+
+	column := err.Column
+	if column == "" && strings.HasPrefix(err.Detail, "Key (") {
+		// unique and foreign key violations only name their columns in the detail, e.g. "Key (email)=(a@b.c) already exists."
+		if end := strings.Index(err.Detail, ")="); end != -1 {
+			column = err.Detail[len("Key ("):end]
 		}
 	}
+	if column != "" {
+		details = append(details, "column: "+column)
+	}
+	return details
 }
 
 // ErrorKind
@@ -73,37 +232,82 @@ type ErrorKind struct {
 	Message string
 	// Severity indicates the level at which this error should be logged at.
 	Severity zapcore.Level
+	// Retryable indicates whether the error is transient, so the same request can succeed if it's sent again.
+	Retryable bool
+	// RetryAfter is how long clients should wait before retrying. It's only used if the error is retryable.
+	RetryAfter time.Duration
 }
 
 var (
 	// KindInvalidJson
-	KindInvalidJson = ErrorKind{400_000, "Invalid JSON", "Your request body contains invalid JSON", zapcore.InfoLevel}
+	KindInvalidJson = ErrorKind{Code: 400_000, Title: "Invalid JSON", Message: "Your request body contains invalid JSON", Severity: zapcore.InfoLevel}
 	// KindStructValidation
 	KindStructValidation = ErrorKind{Code: 400_001, Title: "Bad Data", Message: "Your payload contains invalid data", Severity: zapcore.InfoLevel}
 	// KindInvalidContentType
-	KindInvalidContentType = ErrorKind{400_003, "Invalid Content-Type", "The provided Content-Type was not application/json", zapcore.DebugLevel}
+	KindInvalidContentType = ErrorKind{Code: 400_003, Title: "Invalid Content-Type", Message: "The provided Content-Type was not application/json", Severity: zapcore.DebugLevel}
+	// KindInvalidTokenExchange
+	KindInvalidTokenExchange = ErrorKind{Code: 400_004, Title: "Invalid Token Exchange", Message: "The token exchange request was invalid", Severity: zapcore.InfoLevel}
+	// KindConstraintViolation
+	KindConstraintViolation = ErrorKind{Code: 400_005, Title: "Bad Data", Message: "Your payload contains invalid data", Severity: zapcore.InfoLevel}
 
 	// KindUnauthorized
 	KindUnauthorized = ErrorKind{Code: 401_100, Title: "Unauthorized", Message: "You're not authorized to perform that action", Severity: zapcore.InfoLevel}
 	// KindInvalidCredentials
-	KindInvalidCredentials = ErrorKind{401_001, "Invalid Credentials", "The provided credentials were incorrect", zapcore.DebugLevel}
+	KindInvalidCredentials = ErrorKind{Code: 401_001, Title: "Invalid Credentials", Message: "The provided credentials were incorrect", Severity: zapcore.DebugLevel}
 	// KindInvalidJwt
 	KindInvalidJwt = ErrorKind{Code: 401_002, Title: "Invalid JWT", Message: "The provided refresh or access token was invalid", Severity: zapcore.InfoLevel}
 	// KindExpiredAccessToken
 	KindExpiredAccessToken = ErrorKind{Code: 401_003, Title: "Expired Access Token", Message: "The provided access token was expired", Severity: zapcore.DebugLevel}
+	// KindRevokedAccessToken
+	KindRevokedAccessToken = ErrorKind{Code: 401_004, Title: "Revoked Access Token", Message: "The provided access token has been revoked", Severity: zapcore.InfoLevel}
+	// KindInvalidSession
+	KindInvalidSession = ErrorKind{Code: 401_005, Title: "Invalid Session", Message: "The provided session does not exist or has expired", Severity: zapcore.DebugLevel}
+	// KindInvalidApiKey
+	KindInvalidApiKey = ErrorKind{Code: 401_006, Title: "Invalid API Key", Message: "The provided API key was invalid or has been revoked", Severity: zapcore.InfoLevel}
+	// KindOidc
+	KindOidc = ErrorKind{Code: 401_007, Title: "Login Failed", Message: "Logging in with the external provider failed", Severity: zapcore.WarnLevel}
+	// KindInvalidServiceCredentials
+	KindInvalidServiceCredentials = ErrorKind{Code: 401_008, Title: "Invalid Service Credentials", Message: "The provided service assertion was invalid", Severity: zapcore.WarnLevel}
+	// KindSecondFactorRequired
+	KindSecondFactorRequired = ErrorKind{Code: 401_009, Title: "Second Factor Required", Message: "You must verify your second factor before performing that action", Severity: zapcore.DebugLevel}
+	// KindInvalidSecondFactor
+	KindInvalidSecondFactor = ErrorKind{Code: 401_010, Title: "Invalid Second Factor", Message: "The provided verification or recovery code was incorrect", Severity: zapcore.InfoLevel}
+	// KindInvalidSignedToken
+	KindInvalidSignedToken = ErrorKind{Code: 401_011, Title: "Invalid Link", Message: "The link is invalid, has expired or has already been used", Severity: zapcore.InfoLevel}
+
+	// KindInsufficientScope
+	KindInsufficientScope = ErrorKind{Code: 403_000, Title: "Insufficient Scope", Message: "You're not allowed to perform that action with the provided credentials", Severity: zapcore.InfoLevel}
+	// KindImpersonationForbidden
+	KindImpersonationForbidden = ErrorKind{Code: 403_001, Title: "Impersonation Forbidden", Message: "You're not allowed to impersonate other users", Severity: zapcore.WarnLevel}
 
 	// KindRouteNotFound
-	KindRouteNotFound = ErrorKind{404_000, "Not Found", "The requested url does not exist", zapcore.DebugLevel}
+	KindRouteNotFound = ErrorKind{Code: 404_000, Title: "Not Found", Message: "The requested url does not exist", Severity: zapcore.DebugLevel}
 	// KindRowNotFound
-	KindRowNotFound = ErrorKind{404_001, "Not Found", "The requested resource was not found", zapcore.DebugLevel}
+	KindRowNotFound = ErrorKind{Code: 404_001, Title: "Not Found", Message: "The requested resource was not found", Severity: zapcore.DebugLevel}
 
 	// KindMethodNotAllowed
-	KindMethodNotAllowed = ErrorKind{405_000, "Method Not Allowed", "The requested url does not support that HTTP method", zapcore.DebugLevel}
+	KindMethodNotAllowed = ErrorKind{Code: 405_000, Title: "Method Not Allowed", Message: "The requested url does not support that HTTP method", Severity: zapcore.DebugLevel}
+
+	// KindConflict
+	KindConflict = ErrorKind{Code: 409_000, Title: "Conflict", Message: "The resource already exists", Severity: zapcore.InfoLevel}
+
+	// KindInvalidReference
+	KindInvalidReference = ErrorKind{Code: 422_000, Title: "Invalid Reference", Message: "Your payload references a resource that does not exist", Severity: zapcore.InfoLevel}
+
+	// KindAccountLocked
+	KindAccountLocked = ErrorKind{Code: 423_000, Title: "Account Locked", Message: "Too many failed login attempts, please try again", Severity: zapcore.WarnLevel, Retryable: true}
 
 	// KindUnknown
-	KindUnknown = ErrorKind{500_000, "Unexpected Error", "An unexpected error occurred while processing your request. Please try again later.", zapcore.DPanicLevel}
-	// KindDatabase
-	KindDatabase = ErrorKind{Code: 500_001, Severity: zapcore.ErrorLevel}
+	KindUnknown = ErrorKind{Code: 500_000, Title: "Unexpected Error", Message: "An unexpected error occurred while processing your request. Please try again later.", Severity: zapcore.DPanicLevel}
+	// KindDatabase is returned when a query fails for a reason that isn't classified by its SQLSTATE. The message doesn't
+	// say anything about the database, so nothing about the query is given to the client.
+	KindDatabase = ErrorKind{Code: 500_001, Title: "Database Error", Message: "An unexpected error occurred while processing your request. Please try again later.", Severity: zapcore.ErrorLevel}
+
+	// KindDatabaseTimeout
+	KindDatabaseTimeout = ErrorKind{Code: 503_000, Title: "Timed Out", Message: "Your request took too long to process. Please try again later.", Severity: zapcore.WarnLevel, Retryable: true, RetryAfter: 5 * time.Second}
+	// KindTransactionConflict is returned when a transaction couldn't be completed because of concurrent requests.
+	// The request can safely be retried.
+	KindTransactionConflict = ErrorKind{Code: 503_001, Title: "Try Again", Message: "Your request conflicted with another request. Please try again.", Severity: zapcore.WarnLevel, Retryable: true}
 )
 
 // Error
@@ -117,6 +321,12 @@ func (k ErrorKind) Error() string {
 	return KindUnknown.Title
 }
 
+// Is reports whether the target is an ErrorKind with the same code, so that errors.Is(err, core.KindX) works.
+func (k ErrorKind) Is(target error) bool {
+	kind, ok := target.(ErrorKind)
+	return ok && kind.Code == k.Code
+}
+
 // MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
 func (k ErrorKind) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddInt("code", k.Code)
@@ -128,18 +338,56 @@ func (k ErrorKind) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 // Error
 type Error struct {
 	core    *Core
+	logged  bool
+	stack   []StackFrame
 	Kind    ErrorKind `json:"kind"`
 	Message string    `json:"message"`
 	Details []string  `json:"details"`
-	Cause   error     `json:"err"`
+	// Fields describes the input fields that failed validation. Each of them also has a message in Details.
+	Fields []FieldError `json:"fields"`
+	Cause  error        `json:"err"`
+	// Retryable indicates whether the error is transient. It's true if the kind is retryable, and the error decorator
+	// can also mark errors with transient causes.
+	Retryable bool `json:"retryable"`
+	// RetryAfter is how long clients should wait before retrying. It defaults to the kind's, and can be overridden by
+	// giving a time.Duration to NewError.
+	RetryAfter time.Duration `json:"retryAfter"`
+}
+
+// FieldError describes an input field that failed validation.
+type FieldError struct {
+	// Path is the path of the GraphQL input field (e.g. "todo.title").
+	Path string `json:"path"`
+	// Rule is the validation rule that failed (e.g. "required" or "min").
+	Rule string `json:"rule"`
+	// Param is the parameter of the rule (e.g. "3" for "min=3"), if it has one.
+	Param string `json:"param,omitempty"`
+	// Message is a translated description of the failure.
+	Message string `json:"message"`
+}
+
+// MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
+func (f FieldError) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("path", f.Path)
+	enc.AddString("rule", f.Rule)
+	enc.AddString("param", f.Param)
+	enc.AddString("message", f.Message)
+	return nil
 }
 
 // NewError
 func NewError(core *Core, err error, overrides ...interface{}) Error {
-	if e, ok := err.(Error); ok {
-		// err is already an Error - this method has already been called
+	var existing Error
+	if errors.As(err, &existing) {
+		// err is or wraps an Error - this method has already been called
 		// or it should have been constructed with all of it's fields filled out
-		return e
+		if existing.core == nil {
+			existing.core = core
+		}
+		if !existing.logged {
+			existing.log()
+		}
+		return existing
 	}
 
 	e := Error{
@@ -149,8 +397,9 @@ func NewError(core *Core, err error, overrides ...interface{}) Error {
 		Cause:   err,
 	}
 
-	if kind, ok := err.(ErrorKind); ok {
-		// err is an ErrorKind, replace the kind
+	var kind ErrorKind
+	if errors.As(err, &kind) {
+		// err is or wraps an ErrorKind, replace the kind
 		e.Kind = kind
 		e.Message = kind.Message
 	}
@@ -163,6 +412,9 @@ func NewError(core *Core, err error, overrides ...interface{}) Error {
 			e.Message = override
 		case zapcore.Level:
 			e.Kind.Severity = override
+		case time.Duration:
+			e.Retryable = true
+			e.RetryAfter = override
 		case error:
 			e.Cause = override
 		default:
@@ -172,10 +424,59 @@ func NewError(core *Core, err error, overrides ...interface{}) Error {
 
 	detail(&e)
 
-	core.Logger.Log(e.Kind.Severity, e.Kind.Title+": "+e.Error(), "error", e)
+	e.Retryable = e.Retryable || e.Kind.Retryable
+	if e.RetryAfter == 0 {
+		e.RetryAfter = e.Kind.RetryAfter
+	}
+
+	if e.Kind.Severity >= zapcore.ErrorLevel {
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			e.stack = panicErr.Stack
+		} else {
+			e.stack = captureStack(1)
+		}
+	}
+	e.log()
 	return e
 }
 
+// log logs the error, records an audit event and reports it if needed. Errors are only logged once, no matter how
+// many layers they're passed through.
+func (e *Error) log() {
+	e.logged = true
+
+	msg, keysAndValues := e.Kind.Title+": "+e.Error(), []interface{}{"error", *e}
+	if e.Kind.Severity >= zapcore.ErrorLevel && e.core.Config != nil {
+		if cfg := e.core.Config.CoreConfig().Server.Log.ErrorReporting; cfg != nil {
+			var fields []interface{}
+			msg, fields = googleErrorReportingEntry(e, cfg)
+			keysAndValues = append(keysAndValues, fields...)
+		}
+	}
+
+	e.core.Logger.Log(e.Kind.Severity, msg, keysAndValues...)
+	auditError(e.core, e)
+	reportError(e)
+}
+
+// Unwrap returns the cause of the error, so that errors.Is and errors.As can look through it.
+func (e Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether the target is an ErrorKind or an Error with the same code as the error's kind.
+func (e Error) Is(target error) bool {
+	switch target := target.(type) {
+	case ErrorKind:
+		return e.Kind.Code == target.Code
+	case Error:
+		return e.Kind.Code == target.Kind.Code
+	default:
+		return false
+	}
+}
+
 // Error
 func (e Error) Error() string {
 	if e.Message != "" {
@@ -195,6 +496,17 @@ func (e Error) Extensions() map[string]interface{} {
 		extensions["details"] = e.Details
 	}
 
+	if len(e.Fields) > 0 {
+		extensions["fields"] = e.Fields
+	}
+
+	if e.Retryable {
+		extensions["retryable"] = true
+		if e.RetryAfter > 0 {
+			extensions["retryAfter"] = e.retryAfterSeconds()
+		}
+	}
+
 	if e.core.Config.CoreConfig().Env != EnvProduction {
 		extensions["operations"] = e.core.Operations
 		if e.Cause != nil {
@@ -208,6 +520,11 @@ func (e Error) Extensions() map[string]interface{} {
 	return extensions
 }
 
+// retryAfterSeconds returns RetryAfter rounded up to whole seconds, which is what the Retry-After header uses.
+func (e Error) retryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
 // HttpStatus
 func (e Error) HttpStatus() int {
 	str := strconv.Itoa(e.Kind.Code)
@@ -229,10 +546,24 @@ func (e Error) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 		}
 		return nil
 	}))
-	_ = enc.AddObject("cause", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
-		enc.AddString("type", fmt.Sprintf("%T", e.Cause))
-		enc.AddString("message", e.Cause.Error())
-		return nil
-	}))
+	if e.Retryable {
+		enc.AddBool("retryable", true)
+		enc.AddDuration("retryAfter", e.RetryAfter)
+	}
+	if len(e.Fields) > 0 {
+		_ = enc.AddArray("fields", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			for _, field := range e.Fields {
+				_ = enc.AppendObject(field)
+			}
+			return nil
+		}))
+	}
+	if e.Cause != nil {
+		_ = enc.AddObject("cause", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("type", fmt.Sprintf("%T", e.Cause))
+			enc.AddString("message", e.Cause.Error())
+			return nil
+		}))
+	}
 	return nil
 }
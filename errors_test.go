@@ -0,0 +1,223 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+type testSqlStateError string
+
+func (e testSqlStateError) Error() string    { return "sql state " + string(e) }
+func (e testSqlStateError) SQLState() string { return string(e) }
+
+func TestDefaultErrorDecorator_Postgres(t *testing.T) {
+	c := newTestCore()
+
+	tests := []struct {
+		name    string
+		err     error
+		kind    ErrorKind
+		details []string
+	}{
+		{
+			name:    "unique violation",
+			err:     &pq.Error{Code: "23505", Constraint: "users_email_key", Detail: "Key (email)=(a@b.c) already exists."},
+			kind:    KindConflict,
+			details: []string{"constraint: users_email_key", "column: email"},
+		},
+		{
+			name:    "foreign key violation",
+			err:     &pq.Error{Code: "23503", Constraint: "todos_user_id_fkey", Detail: "Key (user_id)=(1) is not present in table \"users\"."},
+			kind:    KindInvalidReference,
+			details: []string{"constraint: todos_user_id_fkey", "column: user_id"},
+		},
+		{
+			name:    "not null violation",
+			err:     &pq.Error{Code: "23502", Column: "email"},
+			kind:    KindConstraintViolation,
+			details: []string{"column: email"},
+		},
+		{
+			name:    "check violation",
+			err:     &pq.Error{Code: "23514", Constraint: "users_email_check"},
+			kind:    KindConstraintViolation,
+			details: []string{"constraint: users_email_check"},
+		},
+		{name: "serialization failure", err: &pq.Error{Code: "40001"}, kind: KindTransactionConflict, details: []string{}},
+		{name: "deadlock", err: &pq.Error{Code: "40P01"}, kind: KindTransactionConflict, details: []string{}},
+		{name: "statement timeout", err: &pq.Error{Code: "57014"}, kind: KindDatabaseTimeout, details: []string{}},
+		{name: "other", err: &pq.Error{Code: "42P01"}, kind: KindDatabase, details: []string{}},
+		{name: "sql state", err: testSqlStateError("40P01"), kind: KindTransactionConflict, details: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// models wrap driver errors
+			e := NewError(c, fmt.Errorf("models: unable to insert into users: %w", test.err))
+			require.Equal(t, test.kind, e.Kind)
+			require.Equal(t, test.details, e.Details)
+		})
+	}
+}
+
+func TestError_WrapChains(t *testing.T) {
+	defer func(s []AuditSink) { auditSinks = s }(auditSinks)
+	sink := &memoryAuditSink{}
+	auditSinks = []AuditSink{sink}
+
+	c := newTestCore()
+
+	e := NewError(c, KindUnauthorized)
+	wrapped := fmt.Errorf("resolver.Todo: %w", e)
+	require.True(t, errors.Is(wrapped, KindUnauthorized))
+	require.False(t, errors.Is(wrapped, KindInvalidJwt))
+
+	var asError Error
+	require.True(t, errors.As(wrapped, &asError))
+	require.Equal(t, KindUnauthorized.Code, asError.Kind.Code)
+
+	// passing the same error through several layers keeps its kind and only logs it once
+	again := NewError(c, fmt.Errorf("service: %w", wrapped))
+	require.Equal(t, KindUnauthorized, again.Kind)
+	require.Len(t, sink.events, 1)
+
+	// kinds match by code, even if their severity was overridden
+	require.True(t, errors.Is(NewError(c, KindRowNotFound, zapcore.WarnLevel), KindRowNotFound))
+
+	// wrapped kinds and causes are still recognized
+	require.Equal(t, KindRowNotFound, NewError(c, fmt.Errorf("todo 1: %w", KindRowNotFound)).Kind)
+	notFound := NewError(c, fmt.Errorf("models: %w", sql.ErrNoRows))
+	require.Equal(t, KindRowNotFound, notFound.Kind)
+	require.True(t, errors.Is(notFound, sql.ErrNoRows))
+
+	// an Error constructed by hand is logged the first time it's passed to NewError
+	_ = NewError(c, Error{Kind: KindInsufficientScope})
+	require.Len(t, sink.events, 2)
+}
+
+func TestError_Retryable(t *testing.T) {
+	c := newTestCore()
+
+	tests := []struct {
+		name       string
+		args       []interface{}
+		retryable  bool
+		retryAfter time.Duration
+	}{
+		{name: "not retryable", args: []interface{}{KindInvalidCredentials}},
+		{name: "retryable kind", args: []interface{}{KindTransactionConflict}, retryable: true},
+		{name: "retryable kind with delay", args: []interface{}{KindDatabaseTimeout}, retryable: true, retryAfter: 5 * time.Second},
+		{name: "delay override", args: []interface{}{KindAccountLocked, 90 * time.Second}, retryable: true, retryAfter: 90 * time.Second},
+		{name: "deadline exceeded", args: []interface{}{fmt.Errorf("models: %w", context.DeadlineExceeded)}, retryable: true},
+		{name: "bad connection", args: []interface{}{fmt.Errorf("models: %w", driver.ErrBadConn)}, retryable: true},
+		{name: "serialization failure", args: []interface{}{&pq.Error{Code: "40001"}}, retryable: true},
+		{name: "statement timeout", args: []interface{}{&pq.Error{Code: "57014"}}, retryable: true, retryAfter: 5 * time.Second},
+		{name: "unique violation", args: []interface{}{&pq.Error{Code: "23505"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := NewError(c, test.args[0].(error), test.args[1:]...)
+			require.Equal(t, test.retryable, e.Retryable)
+			require.Equal(t, test.retryAfter, e.RetryAfter)
+
+			extensions := e.Extensions()
+			if test.retryable {
+				require.Equal(t, true, extensions["retryable"])
+			} else {
+				require.NotContains(t, extensions, "retryable")
+			}
+			if test.retryAfter > 0 {
+				require.Equal(t, int(test.retryAfter/time.Second), extensions["retryAfter"])
+			} else {
+				require.NotContains(t, extensions, "retryAfter")
+			}
+		})
+	}
+}
+
+func TestResponse_RetryAfter(t *testing.T) {
+	c := newTestCore()
+
+	rec := httptest.NewRecorder()
+	c.w = rec
+	res := &response{core: c}
+	res.writeError(KindAccountLocked, 1500*time.Millisecond)
+	require.Equal(t, 423, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	c.w = rec
+	res = &response{core: c}
+	res.writeError(KindTransactionConflict)
+	require.Empty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestDefaultErrorDecorator_FieldErrors(t *testing.T) {
+	c := newTestCore()
+
+	type item struct {
+		URLPath string `validate:"required"`
+	}
+	args := &struct {
+		Todo struct {
+			Title string `validate:"required,min=3"`
+			Items []item `validate:"dive"`
+		}
+	}{}
+	args.Todo.Title = "a"
+	args.Todo.Items = []item{{}}
+
+	e := NewError(c, validate.Struct(args))
+	require.Equal(t, KindStructValidation, e.Kind)
+	require.Equal(t, []string{"Title must be at least 3 characters in length", "URLPath is a required field"}, e.Details)
+	require.Equal(t, []FieldError{
+		{Path: "todo.title", Rule: "min", Param: "3", Message: "Title must be at least 3 characters in length"},
+		{Path: "todo.items.0.urlPath", Rule: "required", Message: "URLPath is a required field"},
+	}, e.Fields)
+	require.Equal(t, e.Fields, e.Extensions()["fields"])
+}
+
+func TestDefaultErrorDecorator_FieldErrorTags(t *testing.T) {
+	c := newTestCore()
+
+	// names that the heuristic gets wrong can be given with a graphql or json tag
+	type item struct {
+		UserID    int    `json:"userId" validate:"required"`
+		ImageURLs string `graphql:"imageUrls" json:"image_urls" validate:"required"`
+	}
+	args := &struct {
+		TodoItems []item `json:"items,omitempty" validate:"dive"`
+		DueAt     string `json:"-" validate:"required"`
+	}{TodoItems: []item{{}}}
+
+	e := NewError(c, validate.Struct(args))
+	require.Equal(t, []FieldError{
+		{Path: "items.0.userId", Rule: "required", Message: "userId is a required field"},
+		{Path: "items.0.imageUrls", Rule: "required", Message: "imageUrls is a required field"},
+		{Path: "dueAt", Rule: "required", Message: "DueAt is a required field"},
+	}, e.Fields)
+}
+
+func TestGraphqlName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Title":       "title",
+		"CompletedAt": "completedAt",
+		"Id":          "id",
+		"ID":          "id",
+		"URLPath":     "urlPath",
+		"userId":      "userId",
+	} {
+		require.Equal(t, expected, graphqlName(name))
+	}
+}
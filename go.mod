@@ -21,7 +21,7 @@ require (
 	github.com/gopherjs/gopherjs v0.0.0-20200217142428-fce0ec30dd00 // indirect
 	github.com/graph-gophers/graphql-go v0.0.0-20200622220639-c1d9693c95a6
 	github.com/kr/text v0.2.0 // indirect
-	github.com/lib/pq v1.7.0 // indirect
+	github.com/lib/pq v1.7.0
 	github.com/matoous/go-nanoid v1.4.1
 	github.com/mattn/go-colorable v0.1.7 // indirect
 	github.com/mitchellh/mapstructure v1.3.3 // indirect
@@ -41,6 +41,7 @@ require (
 	github.com/stretchr/testify v1.6.1
 	github.com/volatiletech/sqlboiler/v4 v4.2.0 // indirect
 	go.uber.org/zap v1.15.0
+	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899
 	golang.org/x/sys v0.0.0-20200728102440-3e129f6d46b1 // indirect
 	google.golang.org/genproto v0.0.0-20200729003335-053ba62fc06f
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
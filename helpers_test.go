@@ -0,0 +1,42 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// newTestCore returns a *core.Core for a POST request to / with an anonymous session. Access and refresh tokens are
+// configured, everything else is left for the test to configure.
+func newTestCore() *Core {
+	detail = DefaultErrorDecorator
+
+	cfg := &Config{Env: EnvProduction}
+	cfg.Server.Log.Level = "error"
+	cfg.Server.Jwt.AccessToken = JwtConfig{Audience: []string{"*"}, Issuer: "test", ExpiresAt: time.Minute, Secret: "access_token_secret_for_tests"}
+	cfg.Server.Jwt.RefreshToken = &JwtConfig{Audience: []string{"*"}, Issuer: "test", ExpiresAt: time.Hour, Secret: "refresh_token_secret_for_tests"}
+	cfg.Server.Jwt.RefreshCookie = &RefreshCookieConfig{Path: "/", SameSite: "strict", HttpOnly: true}
+
+	c := &Core{
+		Config:  cfg,
+		Context: context.Background(),
+		Request: httptest.NewRequest("POST", "/", nil),
+		w:       httptest.NewRecorder(),
+	}
+	c.Logger = newLogger(cfg).WithCore(c)
+	c.Session = &session{core: c}
+	return c
+}
+
+// responseCookie returns the cookie with the given name that was set on the response of the *core.Core, or nil if it
+// wasn't set.
+func responseCookie(c *Core, name string) *http.Cookie {
+	var found *http.Cookie
+	for _, cookie := range c.w.(*httptest.ResponseRecorder).Result().Cookies() {
+		if cookie.Name == name {
+			found = cookie
+		}
+	}
+	return found
+}
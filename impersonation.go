@@ -0,0 +1,180 @@
+package core
+
+import (
+	"strconv"
+	"time"
+)
+
+// RolesFunc returns the roles of the given subject. The roles are added to the subject's access tokens as the
+// "roles" claim whenever they're issued.
+type RolesFunc func(c *Core, subject string) ([]string, error)
+
+// ImpersonationHook is called when a session starts impersonating a subject, and at the start of every request that
+// is made while impersonating. It can be used to audit what the real subject did as the impersonated subject.
+type ImpersonationHook func(c *Core, realSubject, subject string)
+
+var (
+	// roles is used to fill the roles claim of access tokens.
+	roles RolesFunc
+	// impersonationHook is called whenever an impersonating session is used.
+	impersonationHook ImpersonationHook
+)
+
+// subjectRoles returns the roles of the given subject, or nil if no RolesFunc was given.
+func subjectRoles(c *Core, subject string) []string {
+	if roles == nil {
+		return nil
+	}
+
+	r, err := roles(c, subject)
+	if err != nil {
+		c.Logger.Error("failed to get subject's roles", "error", err, "subject", subject)
+		return nil
+	}
+	return r
+}
+
+// HasRole
+func (s *session) HasRole(role string) bool {
+	if s.IsAnonymous() {
+		return false
+	}
+	for _, r := range s.accessToken.Claims.(*tokenClaims).Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsImpersonating
+func (s *session) IsImpersonating() bool {
+	return s.IsLoggedIn() && s.accessToken.Claims.(*tokenClaims).Imp != nil
+}
+
+// RealSubject
+func (s *session) RealSubject() string {
+	if s.IsImpersonating() {
+		return s.accessToken.Claims.(*tokenClaims).Imp.Subject
+	}
+	return s.Subject()
+}
+
+// Impersonate
+func (s *session) Impersonate(userId int) error {
+	return s.ImpersonateSubject(strconv.Itoa(userId))
+}
+
+// ImpersonateSubject issues an access token for the given subject that also carries the real subject.
+// The refresh token isn't changed, so refreshing the access token ends the impersonation. Subjects with a role the
+// real subject doesn't have can't be impersonated, so impersonation never escalates privileges.
+func (s *session) ImpersonateSubject(subject string) error {
+	cfg := s.core.Config.CoreConfig().Server.Impersonation
+	switch {
+	case cfg == nil:
+		return NewError(s.core, KindImpersonationForbidden, "Impersonation is not enabled")
+	case s.IsAnonymous() || s.IsPartiallyAuthenticated() || s.ServiceId() != "":
+		return NewError(s.core, KindImpersonationForbidden)
+	case s.IsImpersonating():
+		return NewError(s.core, KindImpersonationForbidden, "You're already impersonating someone")
+	case !s.HasRole(cfg.Role):
+		return NewError(s.core, KindImpersonationForbidden)
+	}
+
+	for _, role := range subjectRoles(s.core, subject) {
+		if !s.HasRole(role) {
+			s.core.Logger.Warn("refused to impersonate subject with more roles", "realSubject", s.Subject(), "subject", subject, "role", role)
+			return NewError(s.core, KindImpersonationForbidden, "You can't impersonate someone with roles you don't have")
+		}
+	}
+
+	realSubject := s.Subject()
+	token := generateToken(s.core, subject, false)
+	claims := token.Claims.(*tokenClaims)
+	claims.Imp = &actorClaim{Subject: realSubject}
+	claims.Sid = s.accessToken.Claims.(*tokenClaims).Sid
+	claims.ExpiresAt = time.Now().Add(cfg.ExpiresAt).Unix()
+
+	s.core.Logger.Info("starting impersonation", "realSubject", realSubject, "subject", subject)
+	s.issue(token)
+	s.core.Audit(AuditImpersonate, subject, nil)
+	if impersonationHook != nil {
+		impersonationHook(s.core, realSubject, subject)
+	}
+	return nil
+}
+
+// StopImpersonating issues an access token for the real subject.
+func (s *session) StopImpersonating() {
+	if !s.IsImpersonating() {
+		return
+	}
+	s.core.Audit(AuditStopImpersonating, s.Subject(), nil)
+	token := generateToken(s.core, s.RealSubject(), false)
+	token.Claims.(*tokenClaims).Sid = s.accessToken.Claims.(*tokenClaims).Sid
+	s.issue(token)
+}
+
+// HasRole
+func (s *opaqueSession) HasRole(role string) bool {
+	if s.IsAnonymous() {
+		return false
+	}
+	for _, r := range subjectRoles(s.core, s.stored.Subject) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsImpersonating always returns false, opaque sessions can't impersonate.
+func (s *opaqueSession) IsImpersonating() bool {
+	return false
+}
+
+// RealSubject
+func (s *opaqueSession) RealSubject() string {
+	return s.Subject()
+}
+
+// Impersonate
+func (s *opaqueSession) Impersonate(userId int) error {
+	return s.ImpersonateSubject(strconv.Itoa(userId))
+}
+
+// ImpersonateSubject always returns an error, opaque sessions can't impersonate.
+func (s *opaqueSession) ImpersonateSubject(string) error {
+	return NewError(s.core, KindImpersonationForbidden, "Impersonation is not supported with opaque sessions")
+}
+
+// StopImpersonating does nothing, opaque sessions can't impersonate.
+func (s *opaqueSession) StopImpersonating() {}
+
+// HasRole always returns false, API keys are limited by scopes instead of roles.
+func (s *apiKeySession) HasRole(string) bool {
+	return false
+}
+
+// IsImpersonating always returns false, API keys can't impersonate.
+func (s *apiKeySession) IsImpersonating() bool {
+	return false
+}
+
+// RealSubject
+func (s *apiKeySession) RealSubject() string {
+	return s.Subject()
+}
+
+// Impersonate
+func (s *apiKeySession) Impersonate(userId int) error {
+	return s.ImpersonateSubject(strconv.Itoa(userId))
+}
+
+// ImpersonateSubject always returns an error, API keys can't impersonate.
+func (s *apiKeySession) ImpersonateSubject(string) error {
+	return NewError(s.core, KindImpersonationForbidden, "Impersonation is not supported with API keys")
+}
+
+// StopImpersonating does nothing, API keys can't impersonate.
+func (s *apiKeySession) StopImpersonating() {}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newImpersonationTestCore returns a *core.Core whose session is logged in as the given subject and can impersonate.
+func newImpersonationTestCore(subject string) *Core {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.Impersonation = &ImpersonationConfig{Role: "support", ExpiresAt: time.Minute}
+	c.Session.LoginSubject(subject)
+	return c
+}
+
+// startImpersonationTestSession returns a *core.Core for a request that sends the access token of the given core.
+func startImpersonationTestSession(t *testing.T, from *Core) *Core {
+	c := newTestCore()
+	c.Request.Header.Set("Authorization", "Bearer "+from.Session.AccessToken())
+	require.NoError(t, c.StartSession())
+	return c
+}
+
+// setTestRoles makes the roles func return the given roles of every subject.
+func setTestRoles(subjectRoles map[string][]string) {
+	roles = func(c *Core, subject string) ([]string, error) {
+		return subjectRoles[subject], nil
+	}
+}
+
+func TestSession_ImpersonateSubject(t *testing.T) {
+	defer func(r RolesFunc) { roles = r }(roles)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	defer func(s []AuditSink) { auditSinks = s }(auditSinks)
+	setTestRoles(map[string][]string{"agent": {"support"}, "customer": nil})
+	refreshTokens = NewMemoryRefreshTokenStore()
+	sink := &memoryAuditSink{}
+	auditSinks = []AuditSink{sink}
+
+	c := newImpersonationTestCore("agent")
+	require.NoError(t, c.Session.ImpersonateSubject("customer"))
+	require.True(t, c.Session.IsImpersonating())
+	require.Equal(t, "customer", c.Session.Subject())
+	require.Equal(t, "agent", c.Session.RealSubject())
+
+	// the impersonation is audited as the real subject
+	require.Equal(t, []string{AuditLogin, AuditImpersonate}, sink.actions())
+	require.Equal(t, "agent", sink.events[1].Actor)
+	require.Equal(t, "customer", sink.events[1].Target)
+
+	// the impersonation access token is used like any other
+	next := startImpersonationTestSession(t, c)
+	require.Equal(t, "customer", next.Session.Subject())
+	require.Equal(t, "agent", next.Session.RealSubject())
+	require.Error(t, next.Session.ImpersonateSubject("agent"))
+
+	sink.events = nil
+	c.Session.StopImpersonating()
+	require.False(t, c.Session.IsImpersonating())
+	require.Equal(t, "agent", c.Session.Subject())
+	require.Equal(t, []string{AuditStopImpersonating}, sink.actions())
+
+	// subjects without the role can't impersonate
+	c = newImpersonationTestCore("customer")
+	err := c.Session.ImpersonateSubject("agent")
+	require.Error(t, err)
+	require.Equal(t, KindImpersonationForbidden, err.(Error).Kind)
+}
+
+func TestSession_ImpersonateSubject_Escalation(t *testing.T) {
+	defer func(r RolesFunc) { roles = r }(roles)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	setTestRoles(map[string][]string{
+		"agent": {"support"},
+		"other": {"support"},
+		"admin": {"support", "admin"},
+	})
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	// subjects with a role the real subject doesn't have can't be impersonated
+	c := newImpersonationTestCore("agent")
+	err := c.Session.ImpersonateSubject("admin")
+	require.Error(t, err)
+	require.Equal(t, KindImpersonationForbidden, err.(Error).Kind)
+	require.False(t, c.Session.IsImpersonating())
+
+	require.NoError(t, c.Session.ImpersonateSubject("other"))
+	require.NoError(t, newImpersonationTestCore("admin").Session.ImpersonateSubject("agent"))
+}
+
+func TestSession_ImpersonateSubject_RevokeRealSubject(t *testing.T) {
+	defer func(r RolesFunc) { roles = r }(roles)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	setTestRoles(map[string][]string{"agent": {"support"}})
+	refreshTokens = NewMemoryRefreshTokenStore()
+	denylist = NewMemoryAccessTokenDenylist()
+
+	c := newImpersonationTestCore("agent")
+	require.NoError(t, c.Session.ImpersonateSubject("customer"))
+	customer := newImpersonationTestCore("customer")
+
+	// logging out everywhere while impersonating logs out the real subject, not the impersonated one
+	startImpersonationTestSession(t, c).Session.LogoutEverywhere()
+	tokens, err := refreshTokens.ListSubject(c, "agent")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+	tokens, err = refreshTokens.ListSubject(c, "customer")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	require.True(t, startImpersonationTestSession(t, customer).Session.IsLoggedIn())
+
+	// revoking the real subject also revokes their impersonation access tokens
+	require.NoError(t, newTestCore().RevokeSubject("agent", time.Now().Add(time.Second)))
+	next := newTestCore()
+	next.Request.Header.Set("Authorization", "Bearer "+c.Session.AccessToken())
+	err = next.StartSession()
+	require.Error(t, err)
+	require.Equal(t, KindRevokedAccessToken, err.(Error).Kind)
+}
+
+func TestSession_Sessions_Impersonating(t *testing.T) {
+	defer func(r RolesFunc) { roles = r }(roles)
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	setTestRoles(map[string][]string{"agent": {"support"}})
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	customer := newTestCore()
+	customer.Session.LoginSubject("customer")
+	c := newImpersonationTestCore("agent")
+	require.NoError(t, c.Session.ImpersonateSubject("customer"))
+
+	// device sessions are always the real subject's
+	deviceSessions, err := c.Session.Sessions()
+	require.NoError(t, err)
+	require.Len(t, deviceSessions, 1)
+	require.True(t, deviceSessions[0].Current)
+
+	customerSessions, err := customer.Session.Sessions()
+	require.NoError(t, err)
+	err = c.Session.RevokeSession(customerSessions[0].Id)
+	require.Error(t, err)
+	require.Equal(t, KindRowNotFound, err.(Error).Kind)
+}
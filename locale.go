@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/locales"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	"github.com/go-playground/locales/id"
+	"github.com/go-playground/locales/ja"
+	"github.com/go-playground/locales/nl"
+	"github.com/go-playground/locales/pt_BR"
+	"github.com/go-playground/locales/ru"
+	"github.com/go-playground/locales/tr"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
+	estranslations "github.com/go-playground/validator/v10/translations/es"
+	frtranslations "github.com/go-playground/validator/v10/translations/fr"
+	idtranslations "github.com/go-playground/validator/v10/translations/id"
+	jatranslations "github.com/go-playground/validator/v10/translations/ja"
+	nltranslations "github.com/go-playground/validator/v10/translations/nl"
+	pttranslations "github.com/go-playground/validator/v10/translations/pt_BR"
+	rutranslations "github.com/go-playground/validator/v10/translations/ru"
+	trtranslations "github.com/go-playground/validator/v10/translations/tr"
+	zhtranslations "github.com/go-playground/validator/v10/translations/zh"
+)
+
+// builtinLocales are the locales that validation messages are included for.
+var builtinLocales = map[string]struct {
+	translator func() locales.Translator
+	register   func(*validator.Validate, ut.Translator) error
+}{
+	"en":    {en.New, entranslations.RegisterDefaultTranslations},
+	"es":    {es.New, estranslations.RegisterDefaultTranslations},
+	"fr":    {fr.New, frtranslations.RegisterDefaultTranslations},
+	"id":    {id.New, idtranslations.RegisterDefaultTranslations},
+	"ja":    {ja.New, jatranslations.RegisterDefaultTranslations},
+	"nl":    {nl.New, nltranslations.RegisterDefaultTranslations},
+	"pt_BR": {pt_BR.New, pttranslations.RegisterDefaultTranslations},
+	"ru":    {ru.New, rutranslations.RegisterDefaultTranslations},
+	"tr":    {tr.New, trtranslations.RegisterDefaultTranslations},
+	"zh":    {zh.New, zhtranslations.RegisterDefaultTranslations},
+}
+
+var (
+	// defaultLocale is used when a request doesn't ask for a supported locale.
+	defaultLocale = "en"
+	// supportedLocales maps the lower cased supported locales to their canonical names (e.g. pt_br to pt_BR).
+	supportedLocales = map[string]string{"en": "en"}
+)
+
+// LocaleHook returns the locale the request should use, e.g. the one the subject chose in their settings. It's called
+// after the session has started, and takes precedence over the Accept-Language header. Returning an empty or
+// unsupported locale keeps the negotiated one.
+type LocaleHook func(c *Core) string
+
+// localeHook is called for every request.
+var localeHook LocaleHook
+
+// bundleTranslator is the translator of a locale that is only translated by the bundles. It formats numbers and
+// plurals like English, only its name differs.
+type bundleTranslator struct {
+	locales.Translator
+	locale string
+}
+
+// Locale returns the configured locale name instead of English's.
+func (t bundleTranslator) Locale() string {
+	return t.locale
+}
+
+// setupLocales adds the translations of the configured locales and imports the configured translation bundles.
+// It returns an error if a supported locale has no included validation messages and isn't in the bundles.
+// The included validation messages are registered before the bundles are imported, English ones for locales that are
+// only translated by the bundles, so a bundle entry for a validation rule conflicts with them unless it sets
+// "override": true.
+func setupLocales(cfg *LocaleConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	bundled := map[string]bool{}
+	if cfg.Bundles != "" {
+		var err error
+		if bundled, err = bundleLocales(cfg.Bundles); err != nil {
+			return err
+		}
+	}
+
+	for _, locale := range cfg.Supported {
+		if _, found := uni.GetTranslator(locale); !found {
+			builtin, ok := builtinLocales[locale]
+			switch {
+			case ok:
+				if err := uni.AddTranslator(builtin.translator(), false); err != nil {
+					return err
+				}
+			case bundled[locale]:
+				// validation messages stay in English until the bundles override them
+				builtin = builtinLocales["en"]
+				if err := uni.AddTranslator(bundleTranslator{Translator: en.New(), locale: locale}, false); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("the supported locale %s must be translated by the bundles, validation messages are only included for %s", locale, strings.Join(builtinLocaleNames(), ", "))
+			}
+			trans, _ := uni.GetTranslator(locale)
+			if err := builtin.register(validate, trans); err != nil {
+				return err
+			}
+		}
+		supportedLocales[strings.ToLower(locale)] = locale
+	}
+	locale, ok := supportedLocales[strings.ToLower(cfg.Default)]
+	if !ok {
+		return fmt.Errorf("the default locale %s must be supported", cfg.Default)
+	}
+	defaultLocale = locale
+
+	if cfg.Bundles != "" {
+		return uni.Import(ut.FormatJSON, cfg.Bundles)
+	}
+	return nil
+}
+
+// bundleLocales returns the locales that the JSON translation file, or directory of them, has translations for.
+func bundleLocales(path string) (map[string]bool, error) {
+	found := map[string]bool{}
+	return found, filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var translations []struct {
+			Locale string `json:"locale"`
+		}
+		if err = json.Unmarshal(b, &translations); err != nil {
+			return fmt.Errorf("failed to parse translation bundle %s: %w", path, err)
+		}
+		for _, t := range translations {
+			found[t.Locale] = true
+		}
+		return nil
+	})
+}
+
+// builtinLocaleNames returns the sorted names of the locales that validation messages are included for.
+func builtinLocaleNames() []string {
+	names := make([]string, 0, len(builtinLocales))
+	for name := range builtinLocales {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// negotiateLocale returns the supported locale that best matches the Accept-Language header, or the default locale.
+func negotiateLocale(acceptLanguage string) string {
+	type tag struct {
+		locale string
+		q      float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		t := tag{locale: strings.ToLower(strings.Replace(strings.TrimSpace(fields[0]), "-", "_", -1)), q: 1}
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					t.q = q
+				}
+			}
+		}
+		if t.locale != "" && t.q > 0 {
+			tags = append(tags, t)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		if locale, ok := supportedLocales[t.locale]; ok {
+			return locale
+		}
+		// fr_CA falls back to fr
+		if i := strings.IndexByte(t.locale, '_'); i != -1 {
+			if locale, ok := supportedLocales[t.locale[:i]]; ok {
+				return locale
+			}
+		}
+	}
+	return defaultLocale
+}
+
+// setLocale sets the locale of the request from the LocaleHook or the Accept-Language header.
+func setLocale(c *Core) {
+	c.Locale = negotiateLocale(c.Request.Header.Get("Accept-Language"))
+	if localeHook != nil {
+		if locale, ok := supportedLocales[strings.ToLower(strings.Replace(localeHook(c), "-", "_", -1))]; ok {
+			c.Locale = locale
+		}
+	}
+}
+
+// translator returns the translator of the request's locale.
+func (c *Core) translator() ut.Translator {
+	if c == nil {
+		trans, _ := uni.GetTranslator(defaultLocale)
+		return trans
+	}
+	trans, _ := uni.FindTranslator(c.Locale, defaultLocale)
+	return trans
+}
+
+// Translate returns the translation of the key in the request's locale. The params replace {0}, {1}... in the
+// translation. If there's no translation for the key, the key is returned.
+func (c *Core) Translate(key string, params ...string) string {
+	translation, err := c.translator().T(key, params...)
+	if err != nil {
+		return key
+	}
+	return translation
+}
+
+// localized returns a copy of the error with its kind's title and message translated into the request's locale.
+// Translations are looked up with the keys "error.<code>.title" and "error.<code>.message". Messages that were given
+// to NewError are kept as they are.
+func (e Error) localized() Error {
+	trans := e.core.translator()
+	code := strconv.Itoa(e.Kind.Code)
+
+	if title, err := trans.T("error." + code + ".title"); err == nil {
+		e.Kind.Title = title
+	}
+	if message, err := trans.T("error." + code + ".message"); err == nil {
+		if e.Message == e.Kind.Message {
+			e.Message = message
+		}
+		e.Kind.Message = message
+	}
+	return e
+}
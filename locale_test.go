@@ -0,0 +1,126 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestLocales(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "locales")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fr.json"), []byte(`[
+		{"locale": "fr", "key": "error.404001.title", "trans": "Introuvable", "override": true},
+		{"locale": "fr", "key": "error.404001.message", "trans": "La ressource demandée est introuvable", "override": true},
+		{"locale": "fr", "key": "todo.done", "trans": "{0} est terminé", "override": true}
+	]`), 0600))
+
+	supported := map[string]string{}
+	for k, v := range supportedLocales {
+		supported[k] = v
+	}
+	defaultLocaleBefore := defaultLocale
+
+	require.NoError(t, setupLocales(&LocaleConfig{Default: "en", Supported: []string{"en", "fr", "pt_BR"}, Bundles: dir}))
+	return func() {
+		supportedLocales = supported
+		defaultLocale = defaultLocaleBefore
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	defer setupTestLocales(t)()
+
+	for acceptLanguage, expected := range map[string]string{
+		"":                             "en",
+		"fr":                           "fr",
+		"fr-CA,fr;q=0.9":               "fr",
+		"de-DE,de;q=0.9,fr;q=0.8":      "fr",
+		"en;q=0.5, fr;q=0.8":           "fr",
+		"pt-BR":                        "pt_BR",
+		"pt-PT":                        "en",
+		"fr;q=0, en-US;q=0.3":          "en",
+		"de, ja":                       "en",
+		"*":                            "en",
+		"PT-br;q=0.9, invalid;q=bogus": "pt_BR",
+	} {
+		require.Equal(t, expected, negotiateLocale(acceptLanguage), acceptLanguage)
+	}
+}
+
+func TestError_Localized(t *testing.T) {
+	defer setupTestLocales(t)()
+
+	c := newTestCore()
+	c.Request.Header.Set("Accept-Language", "fr-FR,fr;q=0.9,en;q=0.8")
+	setLocale(c)
+	require.Equal(t, "fr", c.Locale)
+	require.Equal(t, "todo 1 est terminé", c.Translate("todo.done", "todo 1"))
+	require.Equal(t, "todo.missing", c.Translate("todo.missing"))
+
+	e := NewError(c, KindRowNotFound).localized()
+	require.Equal(t, "Introuvable", e.Kind.Title)
+	require.Equal(t, "La ressource demandée est introuvable", e.Error())
+	require.Equal(t, "Introuvable", e.Extensions()["title"])
+
+	// messages given to NewError aren't translated
+	require.Equal(t, "Todo 1 wasn't found", NewError(c, KindRowNotFound, "Todo 1 wasn't found").localized().Error())
+	// kinds without translations stay in english
+	require.Equal(t, KindInvalidJson.Message, NewError(c, KindInvalidJson).localized().Error())
+
+	// validation messages use the request's locale
+	args := &struct {
+		Todo struct {
+			Title string `validate:"required"`
+		}
+	}{}
+	e = NewError(c, validate.Struct(args))
+	require.Equal(t, []string{"Title est un champ obligatoire"}, e.Details)
+
+	// the locale hook takes precedence over the Accept-Language header
+	defer func(h LocaleHook) { localeHook = h }(localeHook)
+	localeHook = func(c *Core) string { return "de" }
+	setLocale(c)
+	require.Equal(t, "fr", c.Locale)
+	localeHook = func(c *Core) string { return "pt-br" }
+	setLocale(c)
+	require.Equal(t, "pt_BR", c.Locale)
+}
+
+func TestSetupLocales_Bundles(t *testing.T) {
+	defer setupTestLocales(t)()
+	dir, err := ioutil.TempDir("", "locales")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "de.json"), []byte(`[
+		{"locale": "de", "key": "error.404001.title", "trans": "Nicht gefunden", "override": true},
+		{"locale": "de", "key": "required", "trans": "{0} ist ein Pflichtfeld", "override": true}
+	]`), 0600))
+
+	// locales without included validation messages need a bundle
+	err = setupLocales(&LocaleConfig{Default: "en", Supported: []string{"en", "it"}, Bundles: dir})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "it")
+
+	require.NoError(t, setupLocales(&LocaleConfig{Default: "en", Supported: []string{"en", "de"}, Bundles: dir}))
+	c := newTestCore()
+	c.Request.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	setLocale(c)
+	require.Equal(t, "de", c.Locale)
+	require.Equal(t, "Nicht gefunden", NewError(c, KindRowNotFound).localized().Kind.Title)
+
+	// validation messages the bundle doesn't translate stay in english
+	args := &struct {
+		Todo struct {
+			Title string `validate:"required"`
+			Notes string `validate:"max=1"`
+		}
+	}{}
+	args.Todo.Notes = "too long"
+	e := NewError(c, validate.Struct(args))
+	require.Equal(t, []string{"Title ist ein Pflichtfeld", "Notes must be a maximum of 1 character in length"}, e.Details)
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginAttempts are the recent failed logins of an identifier (e.g. an email address) or an IP address.
+type LoginAttempts struct {
+	// Key is the identifier or IP address, prefixed with "identifier:" or "ip:".
+	Key string
+	// Failures is how many logins have failed in a row.
+	Failures int
+	// LastFailedAt is when the last login failed.
+	LastFailedAt time.Time
+}
+
+// LoginAttemptStore counts failed logins so they can be throttled.
+type LoginAttemptStore interface {
+	// Find returns the failed logins of the given key, or sql.ErrNoRows if there aren't any.
+	Find(c *Core, key string) (*LoginAttempts, error)
+	// Fail records a failed login for the given key and returns the updated attempts.
+	// Failures that happened before resetBefore are forgotten.
+	Fail(c *Core, key string, resetBefore time.Time) (*LoginAttempts, error)
+	// Reset forgets the failed logins of the given key.
+	Reset(c *Core, key string) error
+}
+
+// LoginLockoutHook is called when an identifier or IP address gets locked out because of too many failed logins.
+// It can be used to alert someone about a possible brute-force or credential stuffing attack.
+type LoginLockoutHook func(c *Core, attempts LoginAttempts)
+
+var (
+	// loginAttempts is the store used to throttle logins.
+	loginAttempts LoginAttemptStore = NewMemoryLoginAttemptStore()
+	// loginLockoutHook is called whenever a key gets locked out.
+	loginLockoutHook LoginLockoutHook
+)
+
+// CheckLoginAttempts returns a KindAccountLocked error if the identifier, or the IP address of the request, has failed
+// to login too many times recently. It should be called before the credentials are checked.
+//
+// It does nothing if server.login_attempts isn't configured.
+func (c *Core) CheckLoginAttempts(identifier string) error {
+	cfg := c.Config.CoreConfig().Server.LoginAttempts
+	if cfg == nil {
+		return nil
+	}
+
+	now := time.Now()
+	for _, key := range c.loginAttemptKeys(identifier) {
+		attempts, err := loginAttempts.Find(c, key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if attempts.LastFailedAt.Before(now.Add(-cfg.ResetAfter)) {
+			continue
+		}
+
+		if wait := attempts.LastFailedAt.Add(cfg.delay(attempts)).Sub(now); wait > 0 {
+			return NewError(c, KindAccountLocked, KindAccountLocked.Message+" in "+wait.Round(time.Second).String(), wait)
+		}
+	}
+	return nil
+}
+
+// LoginFailed records a failed login for the identifier and the IP address of the request.
+//
+// It does nothing if server.login_attempts isn't configured.
+func (c *Core) LoginFailed(identifier string) {
+	cfg := c.Config.CoreConfig().Server.LoginAttempts
+	if cfg == nil {
+		return
+	}
+
+	c.Audit(AuditLoginFailed, identifier, nil)
+
+	resetBefore := time.Now().Add(-cfg.ResetAfter)
+	for _, key := range c.loginAttemptKeys(identifier) {
+		attempts, err := loginAttempts.Fail(c, key, resetBefore)
+		if err != nil {
+			c.Logger.Error("failed to record failed login", "error", err, "key", key)
+			continue
+		}
+
+		if attempts.Failures == cfg.lockoutAfter(key) {
+			c.Logger.Warn("locking out after too many failed logins", "key", key, "failures", attempts.Failures)
+			c.Audit(AuditLockout, key, map[string]interface{}{"failures": attempts.Failures})
+			if loginLockoutHook != nil {
+				loginLockoutHook(c, *attempts)
+			}
+		}
+	}
+}
+
+// LoginSucceeded forgets the failed logins of the identifier. The failed logins of the IP address are kept, otherwise
+// logging in to one account would allow an attacker to keep guessing the passwords of others.
+//
+// It does nothing if server.login_attempts isn't configured.
+func (c *Core) LoginSucceeded(identifier string) {
+	if c.Config.CoreConfig().Server.LoginAttempts == nil {
+		return
+	}
+
+	if err := loginAttempts.Reset(c, loginAttemptIdentifierKey+identifier); err != nil {
+		c.Logger.Error("failed to reset failed logins", "error", err, "identifier", identifier)
+	}
+}
+
+const (
+	loginAttemptIdentifierKey = "identifier:"
+	loginAttemptIpKey         = "ip:"
+)
+
+// loginAttemptKeys returns the keys that failed logins are counted by.
+func (c *Core) loginAttemptKeys(identifier string) []string {
+	keys := []string{loginAttemptIdentifierKey + identifier}
+	if ip := c.remoteIp(); ip != "" {
+		keys = append(keys, loginAttemptIpKey+ip)
+	}
+	return keys
+}
+
+// lockoutAfter returns how many failures lock out the key.
+func (cfg *LoginAttemptsConfig) lockoutAfter(key string) int {
+	if strings.HasPrefix(key, loginAttemptIpKey) {
+		return cfg.IpLockoutAfter
+	}
+	return cfg.LockoutAfter
+}
+
+// delay returns how long after the last failure another login can be attempted.
+// IP addresses are only locked out, backing off would slow down every user behind the same IP address.
+func (cfg *LoginAttemptsConfig) delay(attempts *LoginAttempts) time.Duration {
+	if attempts.Failures >= cfg.lockoutAfter(attempts.Key) {
+		return cfg.LockoutDuration
+	}
+	if attempts.Failures <= cfg.BackoffAfter || strings.HasPrefix(attempts.Key, loginAttemptIpKey) {
+		return 0
+	}
+
+	delay := time.Duration(float64(cfg.BaseDelay) * math.Pow(2, float64(attempts.Failures-cfg.BackoffAfter-1)))
+	if delay > cfg.MaxDelay || delay <= 0 {
+		return cfg.MaxDelay
+	}
+	return delay
+}
+
+// memoryLoginAttemptStore
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempts
+}
+
+// NewMemoryLoginAttemptStore returns a LoginAttemptStore that keeps failed logins in memory.
+// It's the default store, but failed logins are forgotten on restart and aren't shared between instances.
+func NewMemoryLoginAttemptStore() LoginAttemptStore {
+	return &memoryLoginAttemptStore{attempts: map[string]LoginAttempts{}}
+}
+
+// Find
+func (m *memoryLoginAttemptStore) Find(_ *Core, key string) (*LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attempts, ok := m.attempts[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &attempts, nil
+}
+
+// Fail
+func (m *memoryLoginAttemptStore) Fail(_ *Core, key string, resetBefore time.Time) (*LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, attempts := range m.attempts {
+		if attempts.LastFailedAt.Before(resetBefore) {
+			delete(m.attempts, k)
+		}
+	}
+
+	attempts := m.attempts[key]
+	attempts.Key = key
+	attempts.Failures++
+	attempts.LastFailedAt = time.Now()
+	m.attempts[key] = attempts
+	return &attempts, nil
+}
+
+// Reset
+func (m *memoryLoginAttemptStore) Reset(_ *Core, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+// sqlLoginAttemptStore
+type sqlLoginAttemptStore struct{}
+
+// NewSqlLoginAttemptStore returns a LoginAttemptStore that keeps failed logins in the login_attempts table of
+// *core.Core.Db. Take a look at the template's migrations to see what the table should look like.
+func NewSqlLoginAttemptStore() LoginAttemptStore {
+	return sqlLoginAttemptStore{}
+}
+
+// Find
+func (sqlLoginAttemptStore) Find(c *Core, key string) (*LoginAttempts, error) {
+	attempts := &LoginAttempts{}
+	err := c.Db.QueryRowContext(c.Context,
+		"SELECT login_attempt_key, failures, last_failed_at FROM login_attempts WHERE login_attempt_key = $1",
+		key).Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailedAt)
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Fail
+func (sqlLoginAttemptStore) Fail(c *Core, key string, resetBefore time.Time) (*LoginAttempts, error) {
+	attempts := &LoginAttempts{}
+	err := c.Db.QueryRowContext(c.Context,
+		`INSERT INTO login_attempts (login_attempt_key, failures, last_failed_at) VALUES ($1, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT (login_attempt_key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failed_at < $2 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failed_at = CURRENT_TIMESTAMP
+		RETURNING login_attempt_key, failures, last_failed_at`,
+		key, resetBefore).Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailedAt)
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Reset
+func (sqlLoginAttemptStore) Reset(c *Core, key string) error {
+	_, err := c.Db.ExecContext(c.Context, "DELETE FROM login_attempts WHERE login_attempt_key = $1", key)
+	return err
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newLoginAttemptsTestCore(remoteAddr string) *Core {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.LoginAttempts = &LoginAttemptsConfig{
+		BackoffAfter:    2,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		LockoutAfter:    5,
+		IpLockoutAfter:  10,
+		LockoutDuration: 24 * time.Hour,
+		ResetAfter:      24 * time.Hour,
+	}
+	c.Request.RemoteAddr = remoteAddr
+	return c
+}
+
+func TestLoginAttempts_Backoff(t *testing.T) {
+	defer func(s LoginAttemptStore) { loginAttempts = s }(loginAttempts)
+	loginAttempts = NewMemoryLoginAttemptStore()
+	c := newLoginAttemptsTestCore("192.0.2.1:1234")
+
+	c.LoginFailed("user@example.com")
+	c.LoginFailed("user@example.com")
+	require.NoError(t, c.CheckLoginAttempts("user@example.com"))
+
+	c.LoginFailed("user@example.com")
+	err := c.CheckLoginAttempts("user@example.com")
+	require.Error(t, err)
+	require.Equal(t, KindAccountLocked, err.(Error).Kind)
+
+	// other identifiers from the same ip aren't locked yet
+	require.NoError(t, c.CheckLoginAttempts("other@example.com"))
+
+	// succeeding forgets the identifier's failures
+	c.LoginSucceeded("user@example.com")
+	require.NoError(t, c.CheckLoginAttempts("user@example.com"))
+}
+
+func TestLoginAttempts_Lockout(t *testing.T) {
+	defer func(s LoginAttemptStore) { loginAttempts = s }(loginAttempts)
+	defer func(h LoginLockoutHook) { loginLockoutHook = h }(loginLockoutHook)
+	loginAttempts = NewMemoryLoginAttemptStore()
+
+	var locked []string
+	loginLockoutHook = func(c *Core, attempts LoginAttempts) {
+		locked = append(locked, attempts.Key)
+	}
+
+	c := newLoginAttemptsTestCore("192.0.2.2:1234")
+	for i := 0; i < 10; i++ {
+		c.LoginFailed("user@example.com")
+	}
+	require.Equal(t, []string{"identifier:user@example.com", "ip:192.0.2.2"}, locked)
+
+	// the ip is locked out for every identifier
+	err := c.CheckLoginAttempts("other@example.com")
+	require.Error(t, err)
+	require.Equal(t, KindAccountLocked, err.(Error).Kind)
+
+	// other ips can still try other identifiers
+	require.NoError(t, newLoginAttemptsTestCore("192.0.2.3:1234").CheckLoginAttempts("other@example.com"))
+}
+
+func TestLoginAttemptsConfig_Delay(t *testing.T) {
+	cfg := newLoginAttemptsTestCore("").Config.CoreConfig().Server.LoginAttempts
+	delays := map[int]time.Duration{
+		1: 0,
+		2: 0,
+		3: time.Minute,
+		4: 2 * time.Minute,
+		5: 24 * time.Hour,
+	}
+	for failures, expected := range delays {
+		require.Equal(t, expected, cfg.delay(&LoginAttempts{Key: "identifier:user", Failures: failures}), failures)
+	}
+
+	// ip addresses don't back off until they're locked out
+	require.Equal(t, time.Duration(0), cfg.delay(&LoginAttempts{Key: "ip:192.0.2.1", Failures: 9}))
+	require.Equal(t, 24*time.Hour, cfg.delay(&LoginAttempts{Key: "ip:192.0.2.1", Failures: 10}))
+
+	// the delay is capped
+	cfg.LockoutAfter = 20
+	require.Equal(t, time.Hour, cfg.delay(&LoginAttempts{Key: "identifier:user", Failures: 10}))
+}
+
+func TestLoginAttempts_ForwardedFor(t *testing.T) {
+	defer func(s LoginAttemptStore) { loginAttempts = s }(loginAttempts)
+	loginAttempts = NewMemoryLoginAttemptStore()
+
+	// clients can't escape the ip lockout by sending a different X-Forwarded-For with every attempt
+	for i := 0; i < 10; i++ {
+		c := newLoginAttemptsTestCore("192.0.2.4:1234")
+		c.Request.Header.Set("X-Forwarded-For", fmt.Sprintf("198.51.100.%d", i))
+		c.LoginFailed(fmt.Sprintf("user%d@example.com", i))
+	}
+	err := newLoginAttemptsTestCore("192.0.2.4:1234").CheckLoginAttempts("other@example.com")
+	require.Error(t, err)
+	require.Equal(t, KindAccountLocked, err.(Error).Kind)
+}
+
+func TestCore_RemoteIp(t *testing.T) {
+	tests := []struct {
+		remoteAddr    string
+		forwardedFor  string
+		expectedIp    string
+		trustsProxies bool
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1", true},
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1", false},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1", true},
+		// addresses left of the first untrusted one could have been sent by the client
+		{"10.0.0.1:1234", "203.0.113.1, 198.51.100.1, 10.0.0.2", "198.51.100.1", true},
+		{"10.0.0.1:1234", "not an ip, 198.51.100.1", "198.51.100.1", true},
+		{"10.0.0.1:1234", "", "10.0.0.1", true},
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1", true},
+	}
+	for _, test := range tests {
+		c := newTestCore()
+		if test.trustsProxies {
+			c.Config.CoreConfig().Server.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.2"}
+		}
+		c.Request.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			c.Request.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		require.Equal(t, test.expectedIp, c.remoteIp(), test)
+	}
+}
+
+func TestServer_WarnUntrustedForwardedFor(t *testing.T) {
+	for _, trustedProxies := range [][]string{nil, {"10.0.0.0/8"}} {
+		core, logs := observer.New(zap.WarnLevel)
+		c := newTestCore()
+		c.Config.CoreConfig().Server.TrustedProxies = trustedProxies
+		s := &server{config: c.Config, logger: &logger{impl: zap.New(core).Sugar()}}
+		handler := s.warnUntrustedForwardedFor(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-For", "198.51.100.1")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		// the warning is only logged once, and only if there aren't any trusted proxies
+		if trustedProxies == nil {
+			require.Equal(t, 1, logs.Len())
+		} else {
+			require.Equal(t, 0, logs.Len())
+		}
+	}
+}
+
+func TestLoginAttemptsConfig_Validate(t *testing.T) {
+	cfg := newLoginAttemptsTestCore("").Config.CoreConfig().Server.LoginAttempts
+	require.NoError(t, validate.Struct(cfg))
+
+	// failures can't be forgotten before the lockout they caused ends
+	cfg.ResetAfter = time.Hour
+	err := validate.Struct(cfg)
+	require.Error(t, err)
+	require.Equal(t, "ResetAfter", err.(validator.ValidationErrors)[0].Field())
+}
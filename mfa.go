@@ -0,0 +1,219 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30
+)
+
+// totpEncoding is the encoding of TOTP secrets, authenticator apps expect unpadded base32.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret returns a random TOTP secret, encoded as base32.
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TotpUri returns the otpauth:// URI that authenticator apps use to enroll the secret (usually shown as a QR code).
+func TotpUri(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ValidateTotp checks the code against the secret at the given time (RFC 6238), allowing up to skew time steps of
+// clock drift in either direction.
+//
+// It returns the time step the code matched. To prevent a code from being used twice, store the step and reject
+// codes whose step is less than or equal to the stored one.
+func ValidateTotp(secret, code string, at time.Time, skew int) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	step := at.Unix() / totpPeriod
+	for i := -skew; i <= skew; i++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step+int64(i))), []byte(code)) == 1 {
+			return step + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// totpCode returns the HOTP code (RFC 4226) of the key at the given counter.
+func totpCode(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// VerifyTotp checks the code against the secret at the current time, allowing server.mfa.skew time steps of clock
+// drift. See ValidateTotp.
+//
+// lastStep is the step returned by the last successful verification of the secret (0 if there wasn't one). Codes of
+// that step or an earlier one are rejected so a code can't be used twice. The returned step should be stored in its
+// place.
+func (c *Core) VerifyTotp(secret, code string, lastStep int64) (int64, bool) {
+	skew := 1
+	if cfg := c.Config.CoreConfig().Server.Mfa; cfg != nil {
+		skew = cfg.Skew
+	}
+	step, ok := ValidateTotp(secret, code, time.Now(), skew)
+	if !ok || step <= lastStep {
+		return 0, false
+	}
+	return step, true
+}
+
+// TotpUri returns the otpauth:// URI for the account using server.mfa.issuer as the issuer.
+func (c *Core) TotpUri(account, secret string) string {
+	issuer := ""
+	if cfg := c.Config.CoreConfig().Server.Mfa; cfg != nil {
+		issuer = cfg.Issuer
+	}
+	return TotpUri(issuer, account, secret)
+}
+
+// GenerateRecoveryCodes returns n random recovery codes and their hashes.
+// The codes should be shown to the user once, only the hashes should be stored.
+func GenerateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// VerifyRecoveryCode returns the index of the hash that matches the code, or -1 if none of them do.
+// The matching hash should be removed so the code can't be used again.
+func VerifyRecoveryCode(code string, hashes []string) int {
+	hash := hashRecoveryCode(code)
+	match := -1
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			match = i
+		}
+	}
+	return match
+}
+
+// hashRecoveryCode hashes the code, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequireSecondFactor returns an error if the session hasn't verified its second factor yet. Partially authenticated
+// sessions are never logged in, this only gives them a more specific error.
+func (c *Core) RequireSecondFactor() error {
+	if c.Session.IsPartiallyAuthenticated() {
+		return NewError(c, KindSecondFactorRequired)
+	}
+	return nil
+}
+
+// LoginPartially issues a short-lived access token for the subject that is marked as waiting for a second factor.
+// No refresh token is issued until CompleteSecondFactor is called. Until then the session isn't logged in, so
+// resolvers that check IsLoggedIn or IsAnonymous treat it as anonymous, but Subject still returns the subject.
+func (s *session) LoginPartially(subject string) {
+	token := generateToken(s.core, subject, false)
+	claims := token.Claims.(*tokenClaims)
+	claims.SecondFactorPending = true
+	if cfg := s.core.Config.CoreConfig().Server.Mfa; cfg != nil {
+		claims.ExpiresAt = time.Now().Add(cfg.PendingExpiresAt).Unix()
+	}
+	s.issue(token)
+	s.core.Audit(AuditLoginPartially, subject, nil)
+}
+
+// IsPartiallyAuthenticated
+func (s *session) IsPartiallyAuthenticated() bool {
+	return s.hasAccessToken() && s.accessToken.Claims.(*tokenClaims).SecondFactorPending
+}
+
+// CompleteSecondFactor fully logs in the partially authenticated subject.
+func (s *session) CompleteSecondFactor() {
+	if s.IsPartiallyAuthenticated() {
+		s.LoginSubject(s.Subject())
+	}
+}
+
+// LoginPartially replaces the current session (if any) with one for the given subject that's waiting for a second
+// factor. It expires after server.mfa.pending_expires_at and isn't pushed back when it's used.
+func (s *opaqueSession) LoginPartially(subject string) {
+	s.Logout()
+	if s.create(subject, true) {
+		s.core.Audit(AuditLoginPartially, subject, nil)
+	}
+}
+
+// IsPartiallyAuthenticated
+func (s *opaqueSession) IsPartiallyAuthenticated() bool {
+	return s.hasSession() && s.stored.SecondFactorPending
+}
+
+// CompleteSecondFactor replaces the partially authenticated session with a fully logged in one.
+func (s *opaqueSession) CompleteSecondFactor() {
+	if !s.IsPartiallyAuthenticated() {
+		return
+	}
+	subject := s.stored.Subject
+	if err := sessions.Delete(s.core, s.stored.Key); err != nil {
+		s.core.Logger.Error("failed to delete session", "error", err)
+	}
+	*s = opaqueSession{core: s.core}
+	s.LoginSubject(subject)
+}
+
+// LoginPartially does nothing, API key sessions can't log in as another subject.
+func (s *apiKeySession) LoginPartially(string) {
+	s.core.Logger.DPanic("cannot login with an api key session")
+}
+
+// IsPartiallyAuthenticated always returns false, API keys can't be partially authenticated.
+func (s *apiKeySession) IsPartiallyAuthenticated() bool {
+	return false
+}
+
+// CompleteSecondFactor does nothing, API keys can't be partially authenticated.
+func (s *apiKeySession) CompleteSecondFactor() {}
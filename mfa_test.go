@@ -0,0 +1,123 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcTotpSecret is the base32 encoded secret of the RFC 4226 and RFC 6238 test vectors ("12345678901234567890").
+const rfcTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode_Rfc4226(t *testing.T) {
+	// RFC 4226 appendix D
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		require.Equal(t, code, totpCode([]byte("12345678901234567890"), int64(counter)), counter)
+	}
+}
+
+func TestValidateTotp_Rfc6238(t *testing.T) {
+	// RFC 6238 appendix B (SHA1), the codes are the last 6 of the 8 digits
+	for at, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		step, ok := ValidateTotp(rfcTotpSecret, code, time.Unix(at, 0), 0)
+		require.True(t, ok, at)
+		require.Equal(t, at/totpPeriod, step, at)
+	}
+}
+
+func TestValidateTotp_Skew(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	step := at.Unix() / totpPeriod
+	previous := totpCode([]byte("12345678901234567890"), step-1)
+	tooOld := totpCode([]byte("12345678901234567890"), step-2)
+
+	_, ok := ValidateTotp(rfcTotpSecret, previous, at, 0)
+	require.False(t, ok)
+	matched, ok := ValidateTotp(rfcTotpSecret, previous, at, 1)
+	require.True(t, ok)
+	require.Equal(t, step-1, matched)
+	_, ok = ValidateTotp(rfcTotpSecret, tooOld, at, 1)
+	require.False(t, ok)
+
+	for _, invalid := range []string{"", "12345", "1234567", "not a code"} {
+		_, ok = ValidateTotp(rfcTotpSecret, invalid, at, 1)
+		require.False(t, ok, invalid)
+	}
+	_, ok = ValidateTotp("not base32!", "050471", at, 1)
+	require.False(t, ok)
+}
+
+func TestCore_VerifyTotp_Replay(t *testing.T) {
+	c := newTestCore()
+	secret, err := GenerateTotpSecret()
+	require.NoError(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+
+	step, ok := c.VerifyTotp(secret, code, 0)
+	require.True(t, ok)
+
+	// the same code, or any code of the same step, can't be used again
+	_, ok = c.VerifyTotp(secret, code, step)
+	require.False(t, ok)
+	_, ok = c.VerifyTotp(secret, totpCode(key, step-1), step)
+	require.False(t, ok)
+}
+
+func TestVerifyRecoveryCode(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3)
+	require.NoError(t, err)
+	require.Len(t, codes, 3)
+	require.NotContains(t, hashes, codes[0])
+
+	// codes are matched ignoring case and dashes
+	i := VerifyRecoveryCode(" "+strings.ToUpper(strings.Replace(codes[1], "-", "", 1)), hashes)
+	require.Equal(t, 1, i)
+	require.Equal(t, -1, VerifyRecoveryCode("wrong-code", hashes))
+
+	// once its hash is removed, the code can't be used again
+	hashes = append(hashes[:i], hashes[i+1:]...)
+	require.Equal(t, -1, VerifyRecoveryCode(codes[1], hashes))
+	require.Equal(t, 1, VerifyRecoveryCode(codes[2], hashes))
+}
+
+func TestSession_LoginPartially(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	c := newTestCore()
+	c.Config.CoreConfig().Server.Mfa = &MfaConfig{Issuer: "test", Skew: 1, PendingExpiresAt: time.Minute}
+	c.Session.LoginPartially("subject")
+	require.Nil(t, responseCookie(c, refreshTokenKey))
+
+	// partially authenticated sessions aren't logged in, but know who they're verifying
+	next := newTestCore()
+	next.Request.Header.Set("Authorization", "Bearer "+c.Session.AccessToken())
+	require.NoError(t, next.StartSession())
+	require.True(t, next.Session.IsPartiallyAuthenticated())
+	require.False(t, next.Session.IsLoggedIn())
+	require.True(t, next.Session.IsAnonymous())
+	require.Equal(t, "subject", next.Session.Subject())
+	require.False(t, next.Session.HasScope("todos:read"))
+	err := next.RequireSecondFactor()
+	require.Error(t, err)
+	require.Equal(t, KindSecondFactorRequired, err.(Error).Kind)
+
+	next.Session.CompleteSecondFactor()
+	require.False(t, next.Session.IsPartiallyAuthenticated())
+	require.True(t, next.Session.IsLoggedIn())
+	require.Equal(t, "subject", next.Session.Subject())
+	require.NoError(t, next.RequireSecondFactor())
+	require.NotNil(t, responseCookie(next, refreshTokenKey))
+}
@@ -0,0 +1,462 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+)
+
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+	oidcCookieAge   = 10 * time.Minute
+)
+
+// OidcIdentity is the identity of a user that logged in through an OpenID Connect provider.
+type OidcIdentity struct {
+	// Provider is the name of the provider within server.oidc.providers.
+	Provider string
+	// Issuer is the "iss" claim of the ID token.
+	Issuer string
+	// Subject is the "sub" claim of the ID token. It's only unique within the issuer.
+	Subject string
+	// Email is the "email" claim of the ID token, if the provider included it.
+	Email string
+	// EmailVerified is the "email_verified" claim of the ID token.
+	EmailVerified bool
+	// Name is the "name" claim of the ID token, if the provider included it.
+	Name string
+	// Claims contains every claim of the ID token.
+	Claims map[string]interface{}
+}
+
+// OidcCallback maps an external identity to a local subject (e.g. by finding or creating a user).
+// The returned subject is logged in using Session.LoginSubject.
+type OidcCallback func(c *Core, identity OidcIdentity) (string, error)
+
+// oidcDiscovery is the subset of the provider's /.well-known/openid-configuration document that is used.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// oidcProvider
+type oidcProvider struct {
+	name      string
+	cfg       OidcProviderConfig
+	client    *http.Client
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+}
+
+// newOidcProviders
+func newOidcProviders(cfg *OidcConfig) map[string]*oidcProvider {
+	providers := map[string]*oidcProvider{}
+	for name, providerCfg := range cfg.Providers {
+		providers[name] = &oidcProvider{
+			name:   name,
+			cfg:    providerCfg,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return providers
+}
+
+// discover returns the provider's discovery document, fetching it the first time it's needed.
+func (p *oidcProvider) discover(c *Core) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	err := p.getJson(c, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match configured issuer %q", discovery.Issuer, p.cfg.Issuer)
+	}
+
+	p.discovery = discovery
+	return discovery, nil
+}
+
+// key returns the provider's signing key with the given id.
+// The provider's keys are fetched again if the key isn't known, in case they were rotated.
+func (p *oidcProvider) key(c *Core, kid string) (interface{}, error) {
+	discovery, err := p.discover(c)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err = p.getJson(c, discovery.JwksUri, &jwks); err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, err
+			}
+			p.keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, err
+			}
+			p.keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: no signing key with id %q", kid)
+	}
+	return key, nil
+}
+
+// exchange trades the authorization code for the provider's tokens and returns the verified ID token's claims.
+func (p *oidcProvider) exchange(c *Core, code, verifier, nonce string) (jwt.MapClaims, error) {
+	discovery, err := p.discover(c)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectUri(c, p.name)},
+		"client_id":     {p.cfg.ClientId},
+		"client_secret": {p.cfg.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(c.Context, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("oidc: token exchange failed with status %d: %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IdToken == "" {
+		return nil, fmt.Errorf("oidc: token response did not contain an id_token")
+	}
+
+	return p.verify(c, tokens.IdToken, nonce)
+}
+
+// verify checks the ID token's signature against the provider's keys, and its issuer, audience, expiration and nonce.
+func (p *oidcProvider) verify(c *Core, idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, jwt.NewValidationError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]), jwt.ValidationErrorSignatureInvalid)
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(c, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(p.cfg.Issuer, true) {
+		return nil, jwt.NewValidationError("id token has an invalid issuer", jwt.ValidationErrorIssuer)
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, jwt.NewValidationError("id token is expired", jwt.ValidationErrorExpired)
+	}
+	if !oidcAudienceContains(claims["aud"], p.cfg.ClientId) {
+		return nil, jwt.NewValidationError("id token has an invalid audience", jwt.ValidationErrorAudience)
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, jwt.NewValidationError("id token has an invalid nonce", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	return claims, nil
+}
+
+// getJson
+func (p *oidcProvider) getJson(c *Core, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(c.Context, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// oidcLogin redirects the user to the provider's authorization endpoint.
+func (s *server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	core, err := s.newCore(w, r, "server.OidcLogin")
+	res := newResponse(core)
+	if err != nil {
+		res.writeError(err)
+		return
+	}
+
+	provider, ok := s.oidc[chi.URLParam(r, "provider")]
+	if !ok {
+		res.writeError(KindRouteNotFound)
+		return
+	}
+
+	discovery, err := provider.discover(core)
+	if err != nil {
+		res.writeError(err, KindOidc)
+		return
+	}
+
+	state, nonce, verifier := randomUrlString(), randomUrlString(), randomUrlString()
+	if state == "" || nonce == "" || verifier == "" {
+		res.writeError(KindUnknown)
+		return
+	}
+	setOidcCookie(core, provider.name, oidcStateKey, state)
+	setOidcCookie(core, provider.name, oidcNonceKey, nonce)
+	setOidcCookie(core, provider.name, oidcVerifierKey, verifier)
+
+	scopes := provider.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.cfg.ClientId},
+		"redirect_uri":          {oidcRedirectUri(core, provider.name)},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	core.Logger.Debug("redirecting to oidc provider", "provider", provider.name)
+	http.Redirect(w, core.Request, discovery.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// oidcCallbackHandler verifies the provider's response, logs in the user, and redirects them to server.oidc.success_url.
+// If anything fails, the user is redirected to server.oidc.failure_url with the error's code.
+func (s *server) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	core, err := s.newCore(w, r, "server.OidcCallback")
+	res := newResponse(core)
+	if err != nil {
+		res.writeError(err)
+		return
+	}
+
+	provider, ok := s.oidc[chi.URLParam(r, "provider")]
+	if !ok {
+		res.writeError(KindRouteNotFound)
+		return
+	}
+
+	fail := func(err error, args ...interface{}) {
+		e := NewError(core, err, args...)
+		http.Redirect(w, core.Request, oidcFailureUrl(core, e), http.StatusFound)
+	}
+
+	state, _ := core.Request.Cookie(oidcStateKey)
+	nonce, _ := core.Request.Cookie(oidcNonceKey)
+	verifier, _ := core.Request.Cookie(oidcVerifierKey)
+	setOidcCookie(core, provider.name, oidcStateKey, "")
+	setOidcCookie(core, provider.name, oidcNonceKey, "")
+	setOidcCookie(core, provider.name, oidcVerifierKey, "")
+
+	query := core.Request.URL.Query()
+	if query.Get("error") != "" {
+		fail(fmt.Errorf("oidc: provider returned error %q: %s", query.Get("error"), query.Get("error_description")), KindOidc)
+		return
+	}
+	if state == nil || nonce == nil || verifier == nil || state.Value == "" || state.Value != query.Get("state") {
+		fail(KindOidc, "The login request was invalid or has expired. Please try again.")
+		return
+	}
+
+	claims, err := provider.exchange(core, query.Get("code"), verifier.Value, nonce.Value)
+	if err != nil {
+		fail(err, KindOidc)
+		return
+	}
+
+	identity := OidcIdentity{Provider: provider.name, Claims: claims}
+	identity.Issuer, _ = claims["iss"].(string)
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+
+	subject, err := s.oidcCallback(core, identity)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	core.Session.LoginSubject(subject)
+	http.Redirect(w, core.Request, core.Config.CoreConfig().Server.Oidc.SuccessUrl, http.StatusFound)
+}
+
+// oidcRedirectUri returns the url the provider should redirect back to.
+func oidcRedirectUri(c *Core, provider string) string {
+	return strings.TrimSuffix(c.Config.CoreConfig().Server.Oidc.BaseUrl, "/") + "/auth/" + provider + "/callback"
+}
+
+// oidcFailureUrl returns server.oidc.failure_url with the error's code attached.
+func oidcFailureUrl(c *Core, e Error) string {
+	failureUrl := c.Config.CoreConfig().Server.Oidc.FailureUrl
+	separator := "?"
+	if strings.Contains(failureUrl, "?") {
+		separator = "&"
+	}
+	return failureUrl + separator + "error=" + strconv.Itoa(e.Kind.Code)
+}
+
+// setOidcCookie sets (or removes, if value is empty) one of the short lived cookies used during the login flow.
+func setOidcCookie(c *Core, provider, name, value string) {
+	maxAge := int(oidcCookieAge.Seconds())
+	if value == "" {
+		maxAge = -1
+	}
+
+	http.SetCookie(c.w, &http.Cookie{
+		Name:  name,
+		Value: value,
+		// the cookies are only needed by the callback
+		Path:   "/auth/" + provider,
+		MaxAge: maxAge,
+		// lax is required for the cookies to be sent when the provider redirects back
+		SameSite: http.SameSiteLaxMode,
+		Secure:   c.Config.CoreConfig().Server.Oidc.SecureCookies,
+		HttpOnly: true,
+	})
+}
+
+// oidcAudienceContains reports whether the "aud" claim, which can be a string or an array, contains the client id.
+func oidcAudienceContains(aud interface{}, clientId string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// randomUrlString returns a random, URL safe string, or an empty string if the random source failed.
+func randomUrlString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer, as used by JSON web keys.
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIssuer is a minimal OpenID Connect provider.
+type mockIssuer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	clientId  string
+	nonce     string
+	challenge string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	m := &mockIssuer{key: key, clientId: "client"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(challenge[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            m.URL,
+			"aud":            []string{m.clientId},
+			"sub":            "external-id",
+			"email":          "user@example.com",
+			"email_verified": true,
+			"nonce":          m.nonce,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+		})
+		token.Header["kid"] = "key"
+		idToken, err := token.SignedString(key)
+		require.NoError(t, err)
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+func newOidcTestServer(t *testing.T, issuer *mockIssuer, callback OidcCallback) *server {
+	detail = DefaultErrorDecorator
+	cfg := &Config{Env: EnvProduction}
+	cfg.Server.Log.Level = "error"
+	cfg.Server.Cors.AllowedOrigins = []string{"*"}
+	cfg.Server.Jwt.AccessToken = JwtConfig{Audience: []string{"*"}, Issuer: "test", ExpiresAt: time.Minute, Secret: "access_token_secret_for_tests"}
+	cfg.Server.Oidc = &OidcConfig{
+		BaseUrl:    "http://api.test",
+		SuccessUrl: "http://app.test/success",
+		FailureUrl: "http://app.test/failure",
+		Providers: map[string]OidcProviderConfig{
+			"mock": {Issuer: issuer.URL, ClientId: issuer.clientId, ClientSecret: "secret"},
+		},
+	}
+
+	s := &server{
+		config:       cfg,
+		router:       chi.NewRouter(),
+		logger:       newLogger(cfg),
+		decorate:     func(ctx context.Context) context.Context { return ctx },
+		oidcCallback: callback,
+	}
+	s.setupRoutes()
+	return s
+}
+
+// login starts the login flow and returns the cookies and state it set.
+func login(t *testing.T, s *server, issuer *mockIssuer) ([]*http.Cookie, string) {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/mock/login", nil))
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, issuer.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	require.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	require.Equal(t, "http://api.test/auth/mock/callback", location.Query().Get("redirect_uri"))
+
+	issuer.nonce = location.Query().Get("nonce")
+	issuer.challenge = location.Query().Get("code_challenge")
+	return rec.Result().Cookies(), location.Query().Get("state")
+}
+
+func callback(s *server, cookies []*http.Cookie, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/mock/callback?"+query, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOidc_Login(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	var identity OidcIdentity
+	var session Session
+	s := newOidcTestServer(t, issuer, func(c *Core, i OidcIdentity) (string, error) {
+		identity = i
+		session = c.Session
+		return "local-id", nil
+	})
+
+	cookies, state := login(t, s, issuer)
+	rec := callback(s, cookies, url.Values{"code": {"code"}, "state": {state}}.Encode())
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "http://app.test/success", rec.Header().Get("Location"))
+	require.Equal(t, "mock", identity.Provider)
+	require.Equal(t, issuer.URL, identity.Issuer)
+	require.Equal(t, "external-id", identity.Subject)
+	require.Equal(t, "user@example.com", identity.Email)
+	require.True(t, identity.EmailVerified)
+	require.True(t, session.IsLoggedIn())
+	require.Equal(t, "local-id", session.Subject())
+}
+
+func TestOidc_InvalidState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	s := newOidcTestServer(t, issuer, func(c *Core, i OidcIdentity) (string, error) {
+		t.Fatal("callback should not be called")
+		return "", nil
+	})
+
+	cookies, _ := login(t, s, issuer)
+	rec := callback(s, cookies, url.Values{"code": {"code"}, "state": {"forged"}}.Encode())
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "http://app.test/failure?error=401007", rec.Header().Get("Location"))
+}
+
+func TestOidc_InvalidNonce(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	s := newOidcTestServer(t, issuer, func(c *Core, i OidcIdentity) (string, error) {
+		t.Fatal("callback should not be called")
+		return "", nil
+	})
+
+	cookies, state := login(t, s, issuer)
+	issuer.nonce = "replayed"
+	rec := callback(s, cookies, url.Values{"code": {"code"}, "state": {state}}.Encode())
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "http://app.test/failure?error=401007", rec.Header().Get("Location"))
+}
+
+func TestOidc_InvalidCodeVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	s := newOidcTestServer(t, issuer, func(c *Core, i OidcIdentity) (string, error) {
+		t.Fatal("callback should not be called")
+		return "", nil
+	})
+
+	cookies, state := login(t, s, issuer)
+	issuer.challenge = "intercepted"
+	rec := callback(s, cookies, url.Values{"code": {"code"}, "state": {state}}.Encode())
+
+	require.Equal(t, http.StatusFound, rec.Code)
+	require.Equal(t, "http://app.test/failure?error=401007", rec.Header().Get("Location"))
+}
@@ -0,0 +1,447 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	SessionModeJwt    = "jwt"
+	SessionModeOpaque = "opaque"
+)
+
+// StoredSession is the server-side record of an opaque session.
+type StoredSession struct {
+	// Key is the SHA-256 hash of the session id, the id itself is never stored.
+	Key string
+	// Subject is the subject that is logged in.
+	Subject string
+	// CreatedAt is when the session was created.
+	CreatedAt time.Time
+	// LastSeenAt is when the session was last used.
+	LastSeenAt time.Time
+	// ExpiresAt is when the session expires. It's pushed back every time the session is used, unless the session is
+	// waiting for a second factor.
+	ExpiresAt time.Time
+	// SecondFactorPending indicates the subject still needs to verify their second factor.
+	SecondFactorPending bool
+	// Device is a short description of the device the session was created on (e.g. "Firefox on Windows").
+	Device string
+	// Ip is the IP address the session was created from.
+	Ip string
+	// UserAgent is the user agent the session was created with.
+	UserAgent string
+}
+
+// SessionStore persists opaque sessions.
+type SessionStore interface {
+	// Create stores a new session.
+	Create(c *Core, session *StoredSession) error
+	// Find returns the session with the given key, or sql.ErrNoRows if it doesn't exist or has expired.
+	Find(c *Core, key string) (*StoredSession, error)
+	// Touch marks the session with the given key as seen and pushes back when it expires.
+	Touch(c *Core, key string, lastSeenAt, expiresAt time.Time) error
+	// Delete removes the session with the given key.
+	Delete(c *Core, key string) error
+	// DeleteSubject removes every session that belongs to the given subject.
+	DeleteSubject(c *Core, subject string) error
+	// ListSubject returns the sessions of the given subject that haven't expired, most recently seen first.
+	ListSubject(c *Core, subject string) ([]StoredSession, error)
+}
+
+// sessions is the store used when server.session.mode is "opaque".
+var sessions SessionStore = NewMemorySessionStore()
+
+// memorySessionStore
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]StoredSession
+}
+
+// NewMemorySessionStore returns a SessionStore that keeps sessions in memory.
+// It's the default store, but sessions are lost on restart and aren't shared between instances.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: map[string]StoredSession{}}
+}
+
+// Create
+func (m *memorySessionStore) Create(_ *Core, session *StoredSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range m.sessions {
+		if s.ExpiresAt.Before(now) {
+			delete(m.sessions, key)
+		}
+	}
+
+	m.sessions[session.Key] = *session
+	return nil
+}
+
+// Find
+func (m *memorySessionStore) Find(_ *Core, key string) (*StoredSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[key]
+	if !ok || s.ExpiresAt.Before(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+	return &s, nil
+}
+
+// Touch
+func (m *memorySessionStore) Touch(_ *Core, key string, lastSeenAt, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[key]; ok {
+		s.LastSeenAt = lastSeenAt
+		s.ExpiresAt = expiresAt
+		m.sessions[key] = s
+	}
+	return nil
+}
+
+// Delete
+func (m *memorySessionStore) Delete(_ *Core, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, key)
+	return nil
+}
+
+// DeleteSubject
+func (m *memorySessionStore) DeleteSubject(_ *Core, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range m.sessions {
+		if s.Subject == subject {
+			delete(m.sessions, key)
+		}
+	}
+	return nil
+}
+
+// ListSubject
+func (m *memorySessionStore) ListSubject(_ *Core, subject string) ([]StoredSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var found []StoredSession
+	for _, s := range m.sessions {
+		if s.Subject == subject && s.ExpiresAt.After(now) {
+			found = append(found, s)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].LastSeenAt.After(found[j].LastSeenAt) })
+	return found, nil
+}
+
+// sqlSessionStore
+type sqlSessionStore struct{}
+
+// NewSqlSessionStore returns a SessionStore that keeps sessions in the sessions table of *core.Core.Db.
+// Take a look at the template's migrations to see what the table should look like.
+func NewSqlSessionStore() SessionStore {
+	return sqlSessionStore{}
+}
+
+// Create
+func (sqlSessionStore) Create(c *Core, session *StoredSession) error {
+	_, err := c.Db.ExecContext(c.Context,
+		"INSERT INTO sessions (session_key, subject, created_at, last_seen_at, expires_at, second_factor_pending, device, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		session.Key, session.Subject, session.CreatedAt, session.LastSeenAt, session.ExpiresAt, session.SecondFactorPending, session.Device, session.Ip, session.UserAgent)
+	return err
+}
+
+// Find
+func (sqlSessionStore) Find(c *Core, key string) (*StoredSession, error) {
+	s := &StoredSession{}
+	err := c.Db.QueryRowContext(c.Context,
+		"SELECT session_key, subject, created_at, last_seen_at, expires_at, second_factor_pending, device, ip, user_agent FROM sessions WHERE session_key = $1 AND expires_at > CURRENT_TIMESTAMP",
+		key).Scan(&s.Key, &s.Subject, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.SecondFactorPending, &s.Device, &s.Ip, &s.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Touch
+func (sqlSessionStore) Touch(c *Core, key string, lastSeenAt, expiresAt time.Time) error {
+	_, err := c.Db.ExecContext(c.Context,
+		"UPDATE sessions SET last_seen_at = $2, expires_at = $3 WHERE session_key = $1",
+		key, lastSeenAt, expiresAt)
+	return err
+}
+
+// Delete
+func (sqlSessionStore) Delete(c *Core, key string) error {
+	_, err := c.Db.ExecContext(c.Context, "DELETE FROM sessions WHERE session_key = $1", key)
+	return err
+}
+
+// DeleteSubject
+func (sqlSessionStore) DeleteSubject(c *Core, subject string) error {
+	_, err := c.Db.ExecContext(c.Context, "DELETE FROM sessions WHERE subject = $1", subject)
+	return err
+}
+
+// ListSubject
+func (sqlSessionStore) ListSubject(c *Core, subject string) ([]StoredSession, error) {
+	rows, err := c.Db.QueryContext(c.Context,
+		"SELECT session_key, subject, created_at, last_seen_at, expires_at, second_factor_pending, device, ip, user_agent FROM sessions WHERE subject = $1 AND expires_at > CURRENT_TIMESTAMP ORDER BY last_seen_at DESC",
+		subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []StoredSession
+	for rows.Next() {
+		var s StoredSession
+		err = rows.Scan(&s.Key, &s.Subject, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.SecondFactorPending, &s.Device, &s.Ip, &s.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, s)
+	}
+	return found, rows.Err()
+}
+
+// opaqueSession is a Session backed by a random session id and a SessionStore, instead of a JWT.
+type opaqueSession struct {
+	core   *Core
+	id     string
+	stored *StoredSession
+}
+
+// startOpaqueSession looks up the session id within the request and pushes back its expiration.
+func startOpaqueSession(c *Core) error {
+	s := &opaqueSession{core: c}
+	c.Session = s
+
+	id, err := getAccessTokenString(c)
+	if err != nil || id == "" {
+		return err
+	}
+
+	stored, err := sessions.Find(c, sessionKey(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NewError(c, KindInvalidSession)
+		}
+		return NewError(c, err)
+	}
+
+	now := time.Now()
+	stored.LastSeenAt = now
+	// sessions waiting for a second factor don't slide, so they can't be kept alive without verifying it
+	if !stored.SecondFactorPending {
+		stored.ExpiresAt = now.Add(sessionTtl(c))
+	}
+	if err = sessions.Touch(c, stored.Key, stored.LastSeenAt, stored.ExpiresAt); err != nil {
+		c.Logger.Error("failed to touch session", "error", err)
+	}
+
+	s.id = id
+	s.stored = stored
+	// the cookie is sent again so it expires along with the session
+	setSessionId(c, id)
+	return nil
+}
+
+// hasSession reports whether the request has a stored session, even one that's waiting for a second factor.
+func (s *opaqueSession) hasSession() bool {
+	return s.stored != nil
+}
+
+// IsLoggedIn
+func (s *opaqueSession) IsLoggedIn() bool {
+	return s.hasSession() && !s.stored.SecondFactorPending
+}
+
+// IsAnonymous
+func (s *opaqueSession) IsAnonymous() bool {
+	return !s.IsLoggedIn()
+}
+
+// AccessToken returns the session id.
+func (s *opaqueSession) AccessToken() string {
+	return s.id
+}
+
+// RefreshAccessToken reports whether the session is logged in.
+// Opaque sessions use sliding expiration, so there's nothing to refresh.
+func (s *opaqueSession) RefreshAccessToken() bool {
+	return s.IsLoggedIn()
+}
+
+// UserId
+func (s *opaqueSession) UserId() int {
+	if !s.hasSession() {
+		return 0
+	}
+	id, err := strconv.Atoi(s.stored.Subject)
+	if err != nil {
+		s.core.Logger.DPanic("could not parse session's subject to an int", "error", err)
+	}
+	return id
+}
+
+// Subject
+func (s *opaqueSession) Subject() string {
+	if !s.hasSession() {
+		return ""
+	}
+	return s.stored.Subject
+}
+
+// ServiceId
+func (s *opaqueSession) ServiceId() string {
+	return ""
+}
+
+// HasScope
+func (s *opaqueSession) HasScope(string) bool {
+	return s.IsLoggedIn()
+}
+
+// Login
+func (s *opaqueSession) Login(userId int) {
+	s.LoginSubject(strconv.Itoa(userId))
+}
+
+// LoginSubject replaces the current session (if any) with a new one for the given subject.
+func (s *opaqueSession) LoginSubject(subject string) {
+	s.Logout()
+	if s.create(subject, false) {
+		s.core.Audit(AuditLogin, subject, nil)
+		mergeVisitor(s.core, subject)
+	}
+}
+
+// create stores a new session for the given subject and sends its id to the client. It reports whether the session
+// was stored.
+func (s *opaqueSession) create(subject string, secondFactorPending bool) bool {
+	id, err := newSessionId()
+	if err != nil {
+		s.core.Logger.DPanic("issue generating session id", "error", err)
+		return false
+	}
+
+	now := time.Now()
+	stored := &StoredSession{
+		Key:                 sessionKey(id),
+		Subject:             subject,
+		CreatedAt:           now,
+		LastSeenAt:          now,
+		ExpiresAt:           now.Add(sessionTtl(s.core)),
+		SecondFactorPending: secondFactorPending,
+	}
+	if cfg := s.core.Config.CoreConfig().Server.Mfa; secondFactorPending && cfg != nil {
+		stored.ExpiresAt = now.Add(cfg.PendingExpiresAt)
+	}
+	if s.core.Request != nil {
+		stored.Ip = s.core.remoteIp()
+		stored.UserAgent = s.core.Request.UserAgent()
+		stored.Device = deviceName(stored.UserAgent)
+	}
+	if err = sessions.Create(s.core, stored); err != nil {
+		s.core.Logger.Error("failed to store session", "error", err)
+		return false
+	}
+
+	*s = opaqueSession{core: s.core, id: id, stored: stored}
+	setSessionId(s.core, id)
+	return true
+}
+
+// Logout
+func (s *opaqueSession) Logout() {
+	if !s.hasSession() {
+		return
+	}
+	if err := sessions.Delete(s.core, s.stored.Key); err != nil {
+		s.core.Logger.Error("failed to delete session", "error", err)
+	}
+	s.core.Audit(AuditLogout, s.stored.Subject, nil)
+	setSessionId(s.core, "")
+	*s = opaqueSession{core: s.core}
+}
+
+// LogoutEverywhere revokes the subject like core.Core.RevokeSubject, which deletes every one of their sessions.
+func (s *opaqueSession) LogoutEverywhere() {
+	if !s.hasSession() {
+		return
+	}
+	if err := s.core.RevokeSubject(s.stored.Subject, time.Now()); err != nil {
+		s.core.Logger.Error("failed to revoke subject", "error", err, "subject", s.stored.Subject)
+	}
+	s.core.Audit(AuditLogoutEverywhere, s.stored.Subject, nil)
+	setSessionId(s.core, "")
+	*s = opaqueSession{core: s.core}
+}
+
+// Revoke
+func (s *opaqueSession) Revoke() {
+	s.Logout()
+}
+
+// MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
+func (s *opaqueSession) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("mode", SessionModeOpaque)
+	if s.stored != nil {
+		enc.AddString("key", s.stored.Key)
+		enc.AddString("sub", s.stored.Subject)
+		enc.AddTime("expiresAt", s.stored.ExpiresAt)
+		enc.AddBool("mfaPending", s.stored.SecondFactorPending)
+	}
+	return nil
+}
+
+// newSessionId returns a random, URL safe session id.
+func newSessionId() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sessionKey returns the key a session id is stored under.
+func sessionKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// setSessionId sends the session id to the client through server.jwt.access_token_transport, the same way access tokens
+// are sent in the jwt mode. If id is empty, the session cookie is removed.
+func setSessionId(c *Core, id string) {
+	transport := c.Config.CoreConfig().Server.Jwt.AccessTokenTransport
+	if transport == nil || c.w == nil {
+		return
+	}
+	sendAccessToken(c, transport, id, int(sessionTtl(c).Seconds()))
+}
+
+// sessionTtl returns how long an opaque session lasts after it was last used.
+func sessionTtl(c *Core) time.Duration {
+	return c.Config.CoreConfig().Server.Session.Ttl
+}
+
+// isOpaqueSessionMode reports whether server.session.mode is "opaque".
+func isOpaqueSessionMode(c *Core) bool {
+	cfg := c.Config.CoreConfig().Server.Session
+	return cfg != nil && cfg.Mode == SessionModeOpaque
+}
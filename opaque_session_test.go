@@ -0,0 +1,200 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newOpaqueSessionTestCore returns a *core.Core for a request that sends the given session cookie, if it's not nil.
+func newOpaqueSessionTestCore(sessionCookie *http.Cookie) *Core {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.Session = &SessionConfig{Mode: SessionModeOpaque, Ttl: time.Hour}
+	c.Config.CoreConfig().Server.Jwt.AccessTokenTransport = &AccessTokenTransportConfig{
+		Cookie: &CookieConfig{Path: "/", SameSite: "strict"},
+		Header: "X-Access-Token",
+	}
+	if sessionCookie != nil {
+		c.Request.AddCookie(sessionCookie)
+	}
+	return c
+}
+
+func TestOpaqueSession(t *testing.T) {
+	defer func(s SessionStore) { sessions = s }(sessions)
+	sessions = NewMemorySessionStore()
+
+	login := newOpaqueSessionTestCore(nil)
+	require.NoError(t, login.StartSession())
+	require.True(t, login.Session.IsAnonymous())
+
+	login.Session.LoginSubject("subject")
+	id := login.Session.AccessToken()
+	require.NotEmpty(t, id)
+	require.Equal(t, id, login.w.Header().Get("X-Access-Token"))
+	cookie := responseCookie(login, accessTokenKey)
+	require.NotNil(t, cookie)
+	require.Equal(t, id, cookie.Value)
+	require.Equal(t, int(time.Hour.Seconds()), cookie.MaxAge)
+
+	// only the hash of the id is stored
+	_, err := sessions.Find(login, id)
+	require.Error(t, err)
+
+	c := newOpaqueSessionTestCore(cookie)
+	require.NoError(t, c.StartSession())
+	require.True(t, c.Session.IsLoggedIn())
+	require.Equal(t, "subject", c.Session.Subject())
+
+	// the id is also accepted as a bearer token
+	c = newOpaqueSessionTestCore(nil)
+	c.Request.Header.Set("Authorization", "Bearer "+id)
+	require.NoError(t, c.StartSession())
+	require.Equal(t, "subject", c.Session.Subject())
+
+	// logging out deletes the session and removes the cookie
+	c.Session.Logout()
+	require.True(t, c.Session.IsAnonymous())
+	require.Empty(t, responseCookie(c, accessTokenKey).Value)
+	c = newOpaqueSessionTestCore(cookie)
+	err = c.StartSession()
+	require.Error(t, err)
+	require.Equal(t, KindInvalidSession, err.(Error).Kind)
+}
+
+func TestOpaqueSession_SlidingExpiration(t *testing.T) {
+	defer func(s SessionStore) { sessions = s }(sessions)
+	sessions = NewMemorySessionStore()
+
+	login := newOpaqueSessionTestCore(nil)
+	require.NoError(t, login.StartSession())
+	login.Session.LoginSubject("subject")
+	cookie := responseCookie(login, accessTokenKey)
+
+	key := sessionKey(cookie.Value)
+	require.NoError(t, sessions.Touch(login, key, time.Now(), time.Now().Add(time.Minute)))
+
+	// using the session pushes back when it expires
+	c := newOpaqueSessionTestCore(cookie)
+	require.NoError(t, c.StartSession())
+	stored, err := sessions.Find(c, key)
+	require.NoError(t, err)
+	require.True(t, stored.ExpiresAt.After(time.Now().Add(59*time.Minute)))
+	require.Equal(t, int(time.Hour.Seconds()), responseCookie(c, accessTokenKey).MaxAge)
+
+	// expired sessions can't be used
+	require.NoError(t, sessions.Touch(c, key, time.Now(), time.Now().Add(-time.Minute)))
+	c = newOpaqueSessionTestCore(cookie)
+	require.Error(t, c.StartSession())
+	require.True(t, c.Session.IsAnonymous())
+}
+
+func TestOpaqueSession_LogoutEverywhere(t *testing.T) {
+	defer func(s SessionStore) { sessions = s }(sessions)
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	sessions = NewMemorySessionStore()
+	denylist = NewMemoryAccessTokenDenylist()
+
+	var cookies []*http.Cookie
+	for i := 0; i < 2; i++ {
+		c := newOpaqueSessionTestCore(nil)
+		require.NoError(t, c.StartSession())
+		c.Session.LoginSubject("subject")
+		cookies = append(cookies, responseCookie(c, accessTokenKey))
+	}
+
+	c := newOpaqueSessionTestCore(cookies[0])
+	require.NoError(t, c.StartSession())
+	c.Session.LogoutEverywhere()
+	require.True(t, c.Session.IsAnonymous())
+
+	for _, cookie := range cookies {
+		require.Error(t, newOpaqueSessionTestCore(cookie).StartSession())
+	}
+}
+
+func TestOpaqueSession_LoginPartially(t *testing.T) {
+	defer func(s SessionStore) { sessions = s }(sessions)
+	sessions = NewMemorySessionStore()
+
+	login := newOpaqueSessionTestCore(nil)
+	login.Config.CoreConfig().Server.Mfa = &MfaConfig{Issuer: "test", Skew: 1, PendingExpiresAt: time.Minute}
+	require.NoError(t, login.StartSession())
+	login.Session.LoginPartially("subject")
+	cookie := responseCookie(login, accessTokenKey)
+	require.NotNil(t, cookie)
+
+	// partially authenticated sessions aren't logged in, but know who they're verifying
+	c := newOpaqueSessionTestCore(cookie)
+	require.NoError(t, c.StartSession())
+	require.True(t, c.Session.IsPartiallyAuthenticated())
+	require.False(t, c.Session.IsLoggedIn())
+	require.True(t, c.Session.IsAnonymous())
+	require.Equal(t, "subject", c.Session.Subject())
+	require.False(t, c.Session.HasScope("todos:read"))
+
+	// using it doesn't push back when it expires
+	stored, err := sessions.Find(c, sessionKey(cookie.Value))
+	require.NoError(t, err)
+	require.True(t, stored.ExpiresAt.Before(time.Now().Add(time.Minute+time.Second)))
+
+	// completing the second factor replaces the session
+	c.Session.CompleteSecondFactor()
+	require.False(t, c.Session.IsPartiallyAuthenticated())
+	require.True(t, c.Session.IsLoggedIn())
+	require.Equal(t, "subject", c.Session.Subject())
+	require.NotEqual(t, cookie.Value, c.Session.AccessToken())
+	require.Error(t, newOpaqueSessionTestCore(cookie).StartSession())
+}
+
+func TestOpaqueSession_Sessions(t *testing.T) {
+	defer func(s SessionStore) { sessions = s }(sessions)
+	sessions = NewMemorySessionStore()
+
+	var cookies []*http.Cookie
+	for _, userAgent := range []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:79.0) Gecko/20100101 Firefox/79.0", "curl/7.64.1"} {
+		c := newOpaqueSessionTestCore(nil)
+		c.Request.Header.Set("User-Agent", userAgent)
+		require.NoError(t, c.StartSession())
+		c.Session.LoginSubject("subject")
+		cookies = append(cookies, responseCookie(c, accessTokenKey))
+	}
+
+	c := newOpaqueSessionTestCore(cookies[0])
+	require.NoError(t, c.StartSession())
+	deviceSessions, err := c.Session.Sessions()
+	require.NoError(t, err)
+	require.Len(t, deviceSessions, 2)
+	// the current session was seen last
+	require.True(t, deviceSessions[0].Current)
+	require.Equal(t, sessionKey(cookies[0].Value), deviceSessions[0].Id)
+	require.Equal(t, deviceName("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:79.0) Gecko/20100101 Firefox/79.0"), deviceSessions[0].Device)
+	require.False(t, deviceSessions[1].Current)
+
+	require.Error(t, c.Session.RevokeSession("unknown"))
+	require.NoError(t, c.Session.RevokeSession(deviceSessions[1].Id))
+	require.Error(t, newOpaqueSessionTestCore(cookies[1]).StartSession())
+	require.True(t, c.Session.IsLoggedIn())
+
+	// revoking the current session logs out
+	require.NoError(t, c.Session.RevokeSession(deviceSessions[0].Id))
+	require.True(t, c.Session.IsAnonymous())
+	require.Error(t, newOpaqueSessionTestCore(cookies[0]).StartSession())
+}
+
+func TestRevokeSubject_OpaqueSessions(t *testing.T) {
+	defer func(s SessionStore) { sessions = s }(sessions)
+	defer func(d AccessTokenDenylist) { denylist = d }(denylist)
+	sessions = NewMemorySessionStore()
+	denylist = NewMemoryAccessTokenDenylist()
+
+	login := newOpaqueSessionTestCore(nil)
+	require.NoError(t, login.StartSession())
+	login.Session.LoginSubject("subject")
+	cookie := responseCookie(login, accessTokenKey)
+
+	require.NoError(t, newTestCore().RevokeSubject("subject", time.Now()))
+	require.Error(t, newOpaqueSessionTestCore(cookie).StartSession())
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/graph-gophers/graphql-go"
+	graphqlErrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/graph-gophers/graphql-go/trace"
+)
+
+// panicRecoveryKey is used to give the panic recovery middleware the request's *core.Core once it's created.
+const panicRecoveryKey = contextKey(1)
+
+// panicRecovery
+type panicRecovery struct {
+	core *Core
+}
+
+// graphqlPanicPrefix is how graphql-go starts the message of errors it creates from panics.
+const graphqlPanicPrefix = "graphql: panic occurred: "
+
+// PanicError is a panic that was recovered while handling a request.
+type PanicError struct {
+	// Value is the value that was passed to panic.
+	Value interface{}
+	// Stack is where the panic occurred, most recent call first.
+	Stack []StackFrame
+	// Path is the path of the GraphQL field whose resolver panicked (e.g. "todos.0.user"). It's empty if the panic
+	// didn't occur in a resolver.
+	Path string
+}
+
+// Error
+func (p *PanicError) Error() string {
+	if p.Path != "" {
+		return fmt.Sprintf("panic in %s: %v", p.Path, p.Value)
+	}
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// Unwrap returns the value that was passed to panic if it's an error.
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// panicStack returns where the panic that's being recovered occurred. It must be called by the deferred function that
+// recovers the panic.
+func panicStack() []StackFrame {
+	stack := captureStack(1)
+	for i, frame := range stack {
+		if frame.Function != "runtime.gopanic" {
+			continue
+		}
+		// runtime errors (e.g. nil pointer dereferences) go through a few more runtime functions
+		for i++; i < len(stack) && strings.HasPrefix(stack[i].Function, "runtime."); i++ {
+		}
+		return stack[i:]
+	}
+	return stack
+}
+
+// goroutineId returns the id of the calling goroutine, which is the first line of its stack trace
+// ("goroutine 1 [running]:").
+func goroutineId() uint64 {
+	b := make([]byte, 64)
+	b = bytes.TrimPrefix(b[:runtime.Stack(b, false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// graphqlSchemaOptions are the options of the GraphQL schema that turn panics in resolvers into errors.
+func graphqlSchemaOptions(logger Logger) []graphql.SchemaOpt {
+	return []graphql.SchemaOpt{graphql.Logger(panicLogger{logger: logger}), graphql.Tracer(panicTracer{})}
+}
+
+// panicLogger records the panics that graphql-go recovers from resolvers, so they can be turned into errors with their
+// field's path once the query has been executed. graphql-go creates the field's error right after logging the panic,
+// in the same goroutine, so the panic is kept under the goroutine's id until panicTracer pairs it with the error.
+type panicLogger struct {
+	logger Logger
+}
+
+// LogPanic
+func (l panicLogger) LogPanic(ctx context.Context, value interface{}) {
+	p := &PanicError{Value: value, Stack: panicStack()}
+
+	c, ok := ctx.Value(ContextKey).(*Core)
+	if !ok {
+		// graphql-go only calls resolvers with the context given to it, so this should never happen
+		l.logger.Error("recovered a panic outside of a request", "error", p, "stack", formatStack(p.Stack))
+		return
+	}
+
+	c.panicsMu.Lock()
+	defer c.panicsMu.Unlock()
+	if c.loggedPanics == nil {
+		c.loggedPanics = map[uint64]*PanicError{}
+	}
+	c.loggedPanics[goroutineId()] = p
+}
+
+// panicTracer pairs the panics that panicLogger records with the errors graphql-go creates from them, which are unique
+// even if several resolvers panic with the same value. Everything else is traced like graphql-go does by default.
+type panicTracer struct {
+	trace.OpenTracingTracer
+}
+
+// TraceField
+func (t panicTracer) TraceField(ctx context.Context, label, typeName, fieldName string, trivial bool, args map[string]interface{}) (context.Context, trace.TraceFieldFinishFunc) {
+	traceCtx, finish := t.OpenTracingTracer.TraceField(ctx, label, typeName, fieldName, trivial, args)
+	return traceCtx, func(queryErr *graphqlErrors.QueryError) {
+		if c, ok := ctx.Value(ContextKey).(*Core); ok && queryErr != nil && strings.HasPrefix(queryErr.Message, graphqlPanicPrefix) {
+			id := goroutineId()
+			c.panicsMu.Lock()
+			if p, ok := c.loggedPanics[id]; ok {
+				delete(c.loggedPanics, id)
+				if c.panics == nil {
+					c.panics = map[*graphqlErrors.QueryError]*PanicError{}
+				}
+				c.panics[queryErr] = p
+			}
+			c.panicsMu.Unlock()
+		}
+		finish(queryErr)
+	}
+}
+
+// recoveredPanic returns the panic that the query error was created from, or nil if it wasn't created from a panic.
+func (c *Core) recoveredPanic(queryErr *graphqlErrors.QueryError) *PanicError {
+	if !strings.HasPrefix(queryErr.Message, graphqlPanicPrefix) {
+		return nil
+	}
+	value := strings.TrimPrefix(queryErr.Message, graphqlPanicPrefix)
+
+	path := make([]string, len(queryErr.Path))
+	for i, segment := range queryErr.Path {
+		switch segment := segment.(type) {
+		case string:
+			path[i] = segment
+		case int:
+			path[i] = strconv.Itoa(segment)
+		default:
+			path[i] = fmt.Sprint(segment)
+		}
+	}
+
+	c.panicsMu.Lock()
+	defer c.panicsMu.Unlock()
+
+	if p, ok := c.panics[queryErr]; ok {
+		delete(c.panics, queryErr)
+		p.Path = strings.Join(path, ".")
+		return p
+	}
+	// graphql-go also recovers panics outside of resolvers, those aren't paired with their error
+	return &PanicError{Value: value, Path: strings.Join(path, ".")}
+}
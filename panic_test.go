@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/stretchr/testify/require"
+)
+
+type panicTestResolver struct{}
+
+func (*panicTestResolver) Todo() *panicTestTodo { return &panicTestTodo{} }
+
+func (*panicTestResolver) Todos() []*panicTestTodo { return []*panicTestTodo{{}, {}} }
+
+type panicTestTodo struct{}
+
+func (*panicTestTodo) Title() string { panic("title is missing") }
+
+// Body panics with the same value as Title.
+func (*panicTestTodo) Body() string { panic("title is missing") }
+
+func newPanicTestServer(t *testing.T) *server {
+	c := newTestCore()
+	s := &server{
+		config:   c.Config,
+		logger:   newLogger(c.Config.CoreConfig()),
+		decorate: func(ctx context.Context) context.Context { return ctx },
+		router:   chi.NewRouter(),
+	}
+
+	var err error
+	s.schema, err = graphql.ParseSchema(`
+		schema { query: Query }
+		type Query { todo: Todo! todos: [Todo!]! }
+		type Todo { title: String! body: String! }
+	`, &panicTestResolver{}, graphqlSchemaOptions(s.logger)...)
+	require.NoError(t, err)
+
+	s.setupRoutes()
+	return s
+}
+
+func TestRecover_Handler(t *testing.T) {
+	defer func(q *errorReportQueue) { errorReports = q }(errorReports)
+	reporter := &memoryErrorReporter{}
+	errorReports = newErrorReportQueue(reporter, errorReportQueueSize)
+
+	s := newPanicTestServer(t)
+	var requestCore *Core
+	s.router.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		requestCore, _ = s.newCore(w, r, "test.Panic")
+		var todos map[string]*panicTestTodo
+		todos["1"] = &panicTestTodo{}
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	require.True(t, errorReports.shutdown(time.Second))
+	require.Len(t, reporter.reports, 1)
+	report := reporter.reports[0]
+	require.Equal(t, requestCore.Id, report.RequestId)
+	require.Equal(t, []string{"test.Panic", "server.Recover"}, report.Operations)
+	require.Contains(t, report.Stack[0].Function, "TestRecover_Handler")
+
+	var panicErr *PanicError
+	require.True(t, errors.As(report.Error, &panicErr))
+	require.Contains(t, panicErr.Error(), "assignment to entry in nil map")
+}
+
+func TestRecover_Resolver(t *testing.T) {
+	defer func(q *errorReportQueue) { errorReports = q }(errorReports)
+	reporter := &memoryErrorReporter{}
+	errorReports = newErrorReportQueue(reporter, errorReportQueueSize)
+
+	s := newPanicTestServer(t)
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ todo { title } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	var res struct {
+		Errors []struct {
+			Message    string
+			Path       []interface{}
+			Extensions map[string]interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Errors, 1)
+	require.Equal(t, []interface{}{"todo", "title"}, res.Errors[0].Path)
+	require.Equal(t, float64(KindUnknown.Code), res.Errors[0].Extensions["code"])
+
+	require.True(t, errorReports.shutdown(time.Second))
+	require.Len(t, reporter.reports, 1)
+	report := reporter.reports[0]
+	require.Equal(t, []string{"server.Post"}, report.Operations)
+	require.Equal(t, "panicTestTodo).Title", report.Stack[0].Function[strings.LastIndex(report.Stack[0].Function, "*")+1:])
+
+	var panicErr *PanicError
+	require.True(t, errors.As(report.Error, &panicErr))
+	require.Equal(t, "todo.title", panicErr.Path)
+	require.Equal(t, "panic in todo.title: title is missing", panicErr.Error())
+}
+
+func TestRecover_ResolverSameValue(t *testing.T) {
+	defer func(q *errorReportQueue) { errorReports = q }(errorReports)
+	reporter := &memoryErrorReporter{}
+	errorReports = newErrorReportQueue(reporter, errorReportQueueSize)
+
+	s := newPanicTestServer(t)
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ todos { title body } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	// every panic is paired with its own field, even though they have the same value
+	require.True(t, errorReports.shutdown(time.Second))
+	require.Len(t, reporter.reports, 4)
+	paths := map[string]bool{}
+	for _, report := range reporter.reports {
+		var panicErr *PanicError
+		require.True(t, errors.As(report.Error, &panicErr))
+		paths[panicErr.Path] = true
+
+		function := panicErr.Stack[0].Function[strings.LastIndex(panicErr.Stack[0].Function, ".")+1:]
+		require.Equal(t, strings.ToLower(function), panicErr.Path[strings.LastIndex(panicErr.Path, ".")+1:])
+	}
+	require.Equal(t, map[string]bool{"todos.0.title": true, "todos.0.body": true, "todos.1.title": true, "todos.1.body": true}, paths)
+}
+
+func TestPanicLogger_WithoutCore(t *testing.T) {
+	// panics outside of a request are logged instead of crashing the server
+	require.NotPanics(t, func() {
+		panicLogger{logger: newLogger(newTestCore().Config.CoreConfig())}.LogPanic(context.Background(), "title is missing")
+	})
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownPasswordHash is returned when none of the password hashers recognize a hash.
+var ErrUnknownPasswordHash = errors.New("password hash was not created by a known hasher")
+
+// PasswordHasher hashes and verifies passwords.
+type PasswordHasher interface {
+	// Hash returns the hash of the password, including everything needed to verify it later (algorithm, parameters
+	// and salt).
+	Hash(password string) (string, error)
+	// Recognizes reports whether the hash was created by this hasher's algorithm.
+	Recognizes(hash string) bool
+	// Verify reports whether the password matches the hash.
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether the hash was created with different parameters than the hasher's.
+	NeedsRehash(hash string) bool
+}
+
+var (
+	// passwords is used to hash new passwords.
+	passwords PasswordHasher = NewBcryptHasher(bcrypt.DefaultCost)
+	// legacyPasswords are used to verify hashes that weren't created by passwords.
+	legacyPasswords = []PasswordHasher{NewBcryptHasher(bcrypt.DefaultCost), NewArgon2idHasher()}
+)
+
+// HashPassword hashes the password with the configured PasswordHasher.
+func (c *Core) HashPassword(password string) (string, error) {
+	return passwords.Hash(password)
+}
+
+// VerifyPassword checks the password against the hash and returns a KindInvalidCredentials error if it doesn't match.
+//
+// If the hash was created by a different hasher, or with outdated parameters, the password is hashed again with the
+// configured PasswordHasher and the new hash is returned. It should replace the stored hash.
+func (c *Core) VerifyPassword(hash, password string) (string, error) {
+	// hashers aren't compared, because a custom hasher may not be comparable
+	current := passwords.Recognizes(hash)
+	hasher := passwords
+	if !current {
+		hasher = nil
+		for _, legacy := range legacyPasswords {
+			if legacy.Recognizes(hash) {
+				hasher = legacy
+				break
+			}
+		}
+		if hasher == nil {
+			return "", NewError(c, ErrUnknownPasswordHash, KindInvalidCredentials)
+		}
+	}
+
+	ok, err := hasher.Verify(hash, password)
+	if err != nil {
+		return "", NewError(c, err, KindInvalidCredentials)
+	}
+	if !ok {
+		return "", NewError(c, KindInvalidCredentials)
+	}
+
+	if !current || passwords.NeedsRehash(hash) {
+		c.Logger.Debug("rehashing password")
+		return passwords.Hash(password)
+	}
+	return "", nil
+}
+
+// bcryptHasher
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher returns a PasswordHasher that uses bcrypt with the given cost.
+func NewBcryptHasher(cost int) PasswordHasher {
+	return bcryptHasher{cost: cost}
+}
+
+// Hash
+func (b bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	return string(hash), err
+}
+
+// Recognizes
+func (b bcryptHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// Verify
+func (b bcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NeedsRehash
+func (b bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.cost
+}
+
+// Argon2idHasher is a PasswordHasher that uses argon2id. Hashes are encoded in the PHC string format used by the
+// reference implementation, e.g. $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>.
+type Argon2idHasher struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+	// Memory is the amount of memory used in KiB.
+	Memory uint32
+	// Threads is the number of threads used.
+	Threads uint8
+	// KeyLength is the length of the derived key in bytes.
+	KeyLength uint32
+	// SaltLength is the length of the random salt in bytes.
+	SaltLength uint32
+}
+
+// NewArgon2idHasher returns an Argon2idHasher with the parameters recommended by the argon2 package.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Time: 1, Memory: 64 * 1024, Threads: 4, KeyLength: 32, SaltLength: 16}
+}
+
+// Hash
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Recognizes
+func (a *Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// Verify
+func (a *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash
+func (a *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	return err != nil ||
+		params.Time != a.Time || params.Memory != a.Memory || params.Threads != a.Threads ||
+		uint32(len(key)) != a.KeyLength || uint32(len(salt)) != a.SaltLength
+}
+
+// decodeArgon2idHash
+func decodeArgon2idHash(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
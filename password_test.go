@@ -0,0 +1,152 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	c := newTestCore()
+	defer func(p PasswordHasher) { passwords = p }(passwords)
+
+	for _, hasher := range []PasswordHasher{NewBcryptHasher(bcrypt.MinCost), NewArgon2idHasher()} {
+		passwords = hasher
+		hash, err := c.HashPassword("correct horse")
+		require.NoError(t, err)
+		require.True(t, hasher.Recognizes(hash))
+
+		rehash, err := c.VerifyPassword(hash, "correct horse")
+		require.NoError(t, err)
+		require.Empty(t, rehash)
+
+		_, err = c.VerifyPassword(hash, "battery staple")
+		require.Equal(t, KindInvalidCredentials, err.(Error).Kind)
+	}
+}
+
+func TestVerifyPassword_Rehash(t *testing.T) {
+	c := newTestCore()
+	defer func(p PasswordHasher) { passwords = p }(passwords)
+
+	passwords = NewBcryptHasher(bcrypt.MinCost)
+	hash, err := c.HashPassword("correct horse")
+	require.NoError(t, err)
+
+	// switching algorithms upgrades the hash
+	passwords = NewArgon2idHasher()
+	rehash, err := c.VerifyPassword(hash, "correct horse")
+	require.NoError(t, err)
+	require.True(t, passwords.Recognizes(rehash))
+
+	// changing parameters upgrades the hash
+	passwords = &Argon2idHasher{Time: 2, Memory: 32 * 1024, Threads: 2, KeyLength: 32, SaltLength: 16}
+	rehash, err = c.VerifyPassword(rehash, "correct horse")
+	require.NoError(t, err)
+	require.False(t, passwords.NeedsRehash(rehash))
+
+	_, err = c.VerifyPassword(rehash, "correct horse")
+	require.NoError(t, err)
+}
+
+// uncomparableHasher is a PasswordHasher that can't be compared with ==.
+type uncomparableHasher struct {
+	PasswordHasher
+	peppers []string
+}
+
+func TestVerifyPassword_UncomparableHasher(t *testing.T) {
+	c := newTestCore()
+	defer func(p PasswordHasher) { passwords = p }(passwords)
+
+	passwords = uncomparableHasher{PasswordHasher: NewBcryptHasher(bcrypt.MinCost), peppers: []string{"pepper"}}
+	hash, err := c.HashPassword("correct horse")
+	require.NoError(t, err)
+
+	rehash, err := c.VerifyPassword(hash, "correct horse")
+	require.NoError(t, err)
+	require.Empty(t, rehash)
+}
+
+func TestSignedToken(t *testing.T) {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.SignedTokens = &SignedTokensConfig{
+		Secret:                     "signed_token_secret_for_tests",
+		PasswordResetExpiresAt:     time.Hour,
+		EmailVerificationExpiresAt: time.Hour,
+		MagicLinkExpiresAt:         -time.Minute,
+	}
+	defer func(s UsedTokenStore) { usedTokens = s }(usedTokens)
+	usedTokens = NewMemoryUsedTokenStore()
+
+	token, err := c.NewSignedToken(TokenPurposeEmailVerification, "subject")
+	require.NoError(t, err)
+
+	// tokens can't be used for another purpose
+	_, err = c.UseSignedToken(TokenPurposeMagicLink, token)
+	require.Error(t, err)
+
+	subject, err := c.UseSignedToken(TokenPurposeEmailVerification, token)
+	require.NoError(t, err)
+	require.Equal(t, "subject", subject)
+
+	// tokens can only be used once
+	_, err = c.UseSignedToken(TokenPurposeEmailVerification, token)
+	require.Error(t, err)
+
+	// expired tokens can't be used
+	token, err = c.NewSignedToken(TokenPurposeMagicLink, "subject")
+	require.NoError(t, err)
+	_, err = c.UseSignedToken(TokenPurposeMagicLink, token)
+	require.Error(t, err)
+}
+
+func TestBoundSignedToken(t *testing.T) {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.SignedTokens = &SignedTokensConfig{
+		Secret:                     "signed_token_secret_for_tests",
+		PasswordResetExpiresAt:     time.Hour,
+		EmailVerificationExpiresAt: time.Hour,
+		MagicLinkExpiresAt:         time.Hour,
+	}
+	defer func(s UsedTokenStore) { usedTokens = s }(usedTokens)
+	usedTokens = NewMemoryUsedTokenStore()
+
+	passwordHash := "old hash"
+	currentHash := func(subject string) (string, error) {
+		require.Equal(t, "subject", subject)
+		return passwordHash, nil
+	}
+
+	// password reset tokens must be bound
+	_, err := c.NewSignedToken(TokenPurposePasswordReset, "subject")
+	require.Error(t, err)
+
+	token, err := c.NewBoundSignedToken(TokenPurposePasswordReset, "subject", passwordHash)
+	require.NoError(t, err)
+	other, err := c.NewBoundSignedToken(TokenPurposePasswordReset, "subject", passwordHash)
+	require.NoError(t, err)
+	require.NotContains(t, token, passwordHash)
+
+	// bound tokens can't be used without checking their binding
+	_, err = c.UseSignedToken(TokenPurposePasswordReset, token)
+	require.Error(t, err)
+
+	subject, err := c.UseBoundSignedToken(TokenPurposePasswordReset, token, currentHash)
+	require.NoError(t, err)
+	require.Equal(t, "subject", subject)
+
+	// once the password changes, every other token issued for the old one stops working
+	passwordHash = "new hash"
+	_, err = c.UseBoundSignedToken(TokenPurposePasswordReset, other, currentHash)
+	require.Error(t, err)
+	require.Equal(t, KindInvalidSignedToken, err.(Error).Kind)
+
+	// unbound tokens can't be used as bound ones
+	token, err = c.NewSignedToken(TokenPurposeMagicLink, "subject")
+	require.NoError(t, err)
+	_, err = c.UseBoundSignedToken(TokenPurposeMagicLink, token, currentHash)
+	require.Error(t, err)
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned by a RefreshTokenStore when a refresh token that has already been rotated or
+// revoked is rotated again.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// RefreshToken is the server-side record of a refresh token that has been issued.
+//
+// Every login starts a new family. Each time a refresh token is used, it's replaced by a new token in the same family.
+// If a token that has already been replaced is used again, the whole family is revoked.
+type RefreshToken struct {
+	// Id is the "jti" claim of the refresh token.
+	Id string
+	// Family is shared by every refresh token that descends from the same login.
+	Family string
+	// Subject is the "sub" claim of the refresh token.
+	Subject string
+	// IssuedAt is when the refresh token was issued.
+	IssuedAt time.Time
+	// ExpiresAt is when the refresh token expires.
+	ExpiresAt time.Time
+	// RevokedAt is when the refresh token was rotated or revoked, nil if it's still active.
+	RevokedAt *time.Time
+	// ReplacedBy is the id of the refresh token that replaced this one when it was rotated.
+	ReplacedBy string
+	// Device is a short description of the device the refresh token was issued to (e.g. "Firefox on Windows").
+	Device string
+	// Ip is the IP address the refresh token was issued to.
+	Ip string
+	// UserAgent is the user agent the refresh token was issued to.
+	UserAgent string
+}
+
+// RefreshTokenStore persists refresh tokens so they can be rotated and revoked server-side.
+type RefreshTokenStore interface {
+	// Create stores a newly issued refresh token.
+	Create(c *Core, token *RefreshToken) error
+	// Find returns the refresh token with the given id, or sql.ErrNoRows if it doesn't exist.
+	Find(c *Core, id string) (*RefreshToken, error)
+	// Rotate revokes the refresh token with the given id and stores next as its replacement.
+	// It returns ErrRefreshTokenReused if the refresh token was already revoked, or sql.ErrNoRows if it doesn't exist.
+	Rotate(c *Core, id string, next *RefreshToken) error
+	// RevokeFamily revokes every refresh token in the given family.
+	RevokeFamily(c *Core, family string) error
+	// RevokeSubject revokes every refresh token that belongs to the given subject.
+	RevokeSubject(c *Core, subject string) error
+	// ListSubject returns the refresh tokens of the given subject that haven't been revoked or expired, which is one
+	// per family.
+	ListSubject(c *Core, subject string) ([]RefreshToken, error)
+}
+
+// refreshTokens is the store used to rotate and revoke refresh tokens.
+var refreshTokens RefreshTokenStore = NewMemoryRefreshTokenStore()
+
+// memoryRefreshTokenStore
+type memoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryRefreshTokenStore returns a RefreshTokenStore that keeps refresh tokens in memory.
+// It's the default store, but tokens are lost on restart and aren't shared between instances.
+func NewMemoryRefreshTokenStore() RefreshTokenStore {
+	return &memoryRefreshTokenStore{tokens: map[string]RefreshToken{}}
+}
+
+// Create
+func (m *memoryRefreshTokenStore) Create(_ *Core, token *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeExpired()
+	m.tokens[token.Id] = *token
+	return nil
+}
+
+// Find
+func (m *memoryRefreshTokenStore) Find(_ *Core, id string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &token, nil
+}
+
+// Rotate
+func (m *memoryRefreshTokenStore) Rotate(_ *Core, id string, next *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeExpired()
+	token, ok := m.tokens[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if token.RevokedAt != nil {
+		return ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	token.RevokedAt = &now
+	token.ReplacedBy = next.Id
+	m.tokens[id] = token
+	m.tokens[next.Id] = *next
+	return nil
+}
+
+// RevokeFamily
+func (m *memoryRefreshTokenStore) RevokeFamily(_ *Core, family string) error {
+	m.revokeWhere(func(token RefreshToken) bool { return token.Family == family })
+	return nil
+}
+
+// RevokeSubject
+func (m *memoryRefreshTokenStore) RevokeSubject(_ *Core, subject string) error {
+	m.revokeWhere(func(token RefreshToken) bool { return token.Subject == subject })
+	return nil
+}
+
+// ListSubject
+func (m *memoryRefreshTokenStore) ListSubject(_ *Core, subject string) ([]RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var tokens []RefreshToken
+	for _, token := range m.tokens {
+		if token.Subject == subject && token.RevokedAt == nil && token.ExpiresAt.After(now) {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].IssuedAt.After(tokens[j].IssuedAt) })
+	return tokens, nil
+}
+
+// revokeWhere
+func (m *memoryRefreshTokenStore) revokeWhere(match func(RefreshToken) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, token := range m.tokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			m.tokens[id] = token
+		}
+	}
+}
+
+// removeExpired must be called while holding the lock. It's called whenever a token is added, so the store doesn't grow
+// forever.
+func (m *memoryRefreshTokenStore) removeExpired() {
+	now := time.Now()
+	for id, token := range m.tokens {
+		if token.ExpiresAt.Before(now) {
+			delete(m.tokens, id)
+		}
+	}
+}
+
+// sqlRefreshTokenStore
+type sqlRefreshTokenStore struct{}
+
+// NewSqlRefreshTokenStore returns a RefreshTokenStore that keeps refresh tokens in the refresh_tokens table of
+// *core.Core.Db. Take a look at the template's migrations to see what the table should look like.
+func NewSqlRefreshTokenStore() RefreshTokenStore {
+	return sqlRefreshTokenStore{}
+}
+
+// Create
+func (sqlRefreshTokenStore) Create(c *Core, token *RefreshToken) error {
+	_, err := c.Db.ExecContext(c.Context,
+		"INSERT INTO refresh_tokens (refresh_token_id, family, subject, issued_at, expires_at, device, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		token.Id, token.Family, token.Subject, token.IssuedAt, token.ExpiresAt, token.Device, token.Ip, token.UserAgent)
+	return err
+}
+
+// Find
+func (sqlRefreshTokenStore) Find(c *Core, id string) (*RefreshToken, error) {
+	token := &RefreshToken{}
+	var revokedAt sql.NullTime
+	err := c.Db.QueryRowContext(c.Context,
+		"SELECT refresh_token_id, family, subject, issued_at, expires_at, revoked_at, replaced_by, device, ip, user_agent FROM refresh_tokens WHERE refresh_token_id = $1",
+		id).Scan(&token.Id, &token.Family, &token.Subject, &token.IssuedAt, &token.ExpiresAt, &revokedAt, &token.ReplacedBy, &token.Device, &token.Ip, &token.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+// Rotate
+func (sqlRefreshTokenStore) Rotate(c *Core, id string, next *RefreshToken) error {
+	tx, err := c.Db.BeginTx(c.Context, nil)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(c.Context,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, replaced_by = $2 WHERE refresh_token_id = $1 AND revoked_at IS NULL",
+		id, next.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		if err == nil {
+			// the refresh token was either already revoked, or never existed
+			var exists bool
+			err = tx.QueryRowContext(c.Context,
+				"SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE refresh_token_id = $1)",
+				id).Scan(&exists)
+			if err == nil && exists {
+				err = ErrRefreshTokenReused
+			} else if err == nil {
+				err = sql.ErrNoRows
+			}
+		}
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(c.Context,
+		"INSERT INTO refresh_tokens (refresh_token_id, family, subject, issued_at, expires_at, device, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		next.Id, next.Family, next.Subject, next.IssuedAt, next.ExpiresAt, next.Device, next.Ip, next.UserAgent)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeFamily
+func (sqlRefreshTokenStore) RevokeFamily(c *Core, family string) error {
+	_, err := c.Db.ExecContext(c.Context,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family = $1 AND revoked_at IS NULL",
+		family)
+	return err
+}
+
+// RevokeSubject
+func (sqlRefreshTokenStore) RevokeSubject(c *Core, subject string) error {
+	_, err := c.Db.ExecContext(c.Context,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE subject = $1 AND revoked_at IS NULL",
+		subject)
+	return err
+}
+
+// ListSubject
+func (sqlRefreshTokenStore) ListSubject(c *Core, subject string) ([]RefreshToken, error) {
+	rows, err := c.Db.QueryContext(c.Context,
+		"SELECT refresh_token_id, family, subject, issued_at, expires_at, replaced_by, device, ip, user_agent FROM refresh_tokens WHERE subject = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP ORDER BY issued_at DESC",
+		subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []RefreshToken
+	for rows.Next() {
+		var token RefreshToken
+		err = rows.Scan(&token.Id, &token.Family, &token.Subject, &token.IssuedAt, &token.ExpiresAt, &token.ReplacedBy, &token.Device, &token.Ip, &token.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newRefreshTestCore returns a *core.Core for a request that sends the refresh token cookie.
+func newRefreshTestCore(refreshTokenCookie *http.Cookie) *Core {
+	c := newTestCore()
+	c.Request.AddCookie(refreshTokenCookie)
+	return c
+}
+
+func TestSession_RefreshAccessToken(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	login := newTestCore()
+	login.Session.LoginSubject("subject")
+	first := responseCookie(login, refreshTokenKey)
+	require.NotNil(t, first)
+
+	c := newRefreshTestCore(first)
+	require.True(t, c.Session.RefreshAccessToken())
+	require.Equal(t, "subject", c.Session.Subject())
+	second := responseCookie(c, refreshTokenKey)
+	require.NotNil(t, second)
+	require.NotEqual(t, first.Value, second.Value)
+
+	c = newRefreshTestCore(second)
+	require.True(t, c.Session.RefreshAccessToken())
+}
+
+func TestSession_RefreshAccessToken_Reuse(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	login := newTestCore()
+	login.Session.LoginSubject("subject")
+	first := responseCookie(login, refreshTokenKey)
+
+	c := newRefreshTestCore(first)
+	require.True(t, c.Session.RefreshAccessToken())
+	second := responseCookie(c, refreshTokenKey)
+
+	// reusing the rotated token revokes the whole family, including the token that replaced it
+	c = newRefreshTestCore(first)
+	require.False(t, c.Session.RefreshAccessToken())
+	require.True(t, c.Session.IsAnonymous())
+	require.Empty(t, responseCookie(c, refreshTokenKey).Value)
+
+	c = newRefreshTestCore(second)
+	require.False(t, c.Session.RefreshAccessToken())
+
+	tokens, err := refreshTokens.ListSubject(c, "subject")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestSession_RefreshAccessToken_Expired(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	c := newTestCore()
+	refreshToken, record := newRefreshToken(c, "subject", "")
+	refreshToken.Claims.(*tokenClaims).ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	require.NoError(t, refreshTokens.Create(c, record))
+	signed, err := refreshToken.SignedString([]byte(c.Config.CoreConfig().Server.Jwt.RefreshToken.Secret))
+	require.NoError(t, err)
+
+	c = newRefreshTestCore(&http.Cookie{Name: refreshTokenKey, Value: signed})
+	require.False(t, c.Session.RefreshAccessToken())
+	require.True(t, c.Session.IsAnonymous())
+	require.Empty(t, responseCookie(c, refreshTokenKey).Value)
+}
+
+func TestSession_RefreshAccessToken_Concurrent(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	login := newTestCore()
+	login.Config.CoreConfig().Server.Jwt.RefreshTokenReuseInterval = time.Minute
+	login.Session.LoginSubject("subject")
+	first := responseCookie(login, refreshTokenKey)
+
+	c := newRefreshTestCore(first)
+	c.Config.CoreConfig().Server.Jwt.RefreshTokenReuseInterval = time.Minute
+	require.True(t, c.Session.RefreshAccessToken())
+	second := responseCookie(c, refreshTokenKey)
+
+	// a request that was sent at the same time still gets an access token, without rotating again or revoking
+	c = newRefreshTestCore(first)
+	c.Config.CoreConfig().Server.Jwt.RefreshTokenReuseInterval = time.Minute
+	require.True(t, c.Session.RefreshAccessToken())
+	require.Equal(t, "subject", c.Session.Subject())
+	require.Nil(t, responseCookie(c, refreshTokenKey))
+
+	c = newRefreshTestCore(second)
+	require.True(t, c.Session.RefreshAccessToken())
+}
+
+func TestMemoryRefreshTokenStore_Rotate(t *testing.T) {
+	store := NewMemoryRefreshTokenStore()
+	c := newTestCore()
+	_, record := newRefreshToken(c, "subject", "")
+	require.NoError(t, store.Create(c, record))
+
+	// only one of the concurrent rotations wins
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, next := newRefreshToken(c, "subject", record.Family)
+			errs <- store.Rotate(c, record.Id, next)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	rotated := 0
+	for err := range errs {
+		if err == nil {
+			rotated++
+		} else {
+			require.Equal(t, ErrRefreshTokenReused, err)
+		}
+	}
+	require.Equal(t, 1, rotated)
+}
+
+func TestMemoryRefreshTokenStore_RemoveExpired(t *testing.T) {
+	store := NewMemoryRefreshTokenStore()
+	c := newTestCore()
+	_, expired := newRefreshToken(c, "subject", "")
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	_, record := newRefreshToken(c, "subject", "")
+	require.NoError(t, store.Create(c, expired))
+	require.NoError(t, store.Create(c, record))
+
+	// rotating also removes expired tokens, so a store that only rotates doesn't grow forever
+	_, next := newRefreshToken(c, "subject", record.Family)
+	require.NoError(t, store.Rotate(c, record.Id, next))
+	_, err := store.Find(c, expired.Id)
+	require.Error(t, err)
+}
@@ -9,22 +9,31 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
 	"github.com/graph-gophers/graphql-go"
 	graphqlErrors "github.com/graph-gophers/graphql-go/errors"
 	nanoid "github.com/matoous/go-nanoid"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
 	projectId = os.Getenv("GOOGLE_CLOUD_PROJECT")
 )
 
+// shutdownTimeout is how long the server waits for requests to finish, and then for queued error reports to be
+// sent, when it's shut down.
+const shutdownTimeout = 10 * time.Second
+
 // request
 type request struct {
 	Query         string                 `json:"query"`
@@ -37,6 +46,8 @@ type response struct {
 	core   *Core
 	result *graphql.Response
 	status int
+	// retryAfter is the number of seconds sent in the Retry-After header, if it's greater than 0.
+	retryAfter int
 }
 
 // newResponse
@@ -58,6 +69,9 @@ func (r *response) write() {
 
 	r.result.Extensions = r.core.Extensions()
 	r.core.w.Header().Add("Content-Type", "application/json")
+	if r.retryAfter > 0 {
+		r.core.w.Header().Set("Retry-After", strconv.Itoa(r.retryAfter))
+	}
 	r.core.w.WriteHeader(r.status)
 
 	err := json.NewEncoder(r.core.w).Encode(r.result)
@@ -66,10 +80,19 @@ func (r *response) write() {
 	}
 }
 
+// addRetryAfter makes sure the client waits long enough before retrying the error. When there are several errors,
+// the longest wait is used.
+func (r *response) addRetryAfter(e Error) {
+	if e.Retryable && e.retryAfterSeconds() > r.retryAfter {
+		r.retryAfter = e.retryAfterSeconds()
+	}
+}
+
 // writeError
 func (r *response) writeError(err error, args ...interface{}) {
-	e := NewError(r.core, err, args...)
+	e := NewError(r.core, err, args...).localized()
 	r.status = e.HttpStatus()
+	r.addRetryAfter(e)
 
 	r.result = &graphql.Response{
 		Errors: []*graphqlErrors.QueryError{
@@ -93,6 +116,12 @@ type server struct {
 	resolver interface{}
 	decorate ResolverContextDecorator
 	db       *sql.DB
+
+	oidc         map[string]*oidcProvider
+	oidcCallback OidcCallback
+
+	// untrustedForwardedFor warns once that X-Forwarded-For is ignored
+	untrustedForwardedFor sync.Once
 }
 
 // newCore
@@ -134,23 +163,41 @@ func (s *server) newCore(w http.ResponseWriter, r *http.Request, operation strin
 	// attach request to core with new decorated context
 	core.Request = r.WithContext(core.Context)
 
-	return core, core.StartSession()
+	if recovery, ok := r.Context().Value(panicRecoveryKey).(*panicRecovery); ok {
+		recovery.core = core
+	}
+
+	err := core.StartSession()
+	setLocale(core)
+	return core, err
 }
 
 // routes
 func (s *server) setupRoutes() {
-	s.router.Use(middleware.RealIP)
 	s.router.Use(cors.New(s.config.CoreConfig().Server.corsOptions()).Handler)
+	s.router.Use(s.warnUntrustedForwardedFor)
 	s.router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// newCore stores the request's core here, so the panic is logged with the request's id and operations
+			recovery := &panicRecovery{}
+			r = r.WithContext(context.WithValue(r.Context(), panicRecoveryKey, recovery))
+
 			defer func() {
 				if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
-					s.logger.Error("recovering from panic", "error", rvr)
-
-					// error would be session related, which doesn't matter here
-					core, _ := s.newCore(w, r, "server.Recover")
+					p := &PanicError{Value: rvr, Stack: panicStack()}
+
+					core := recovery.core
+					if core == nil {
+						// the panic occurred before the core was created
+						// error would be session related, which doesn't matter here
+						core, _ = s.newCore(w, r, "server.Recover")
+					} else {
+						core.AddOp("server.Recover")
+					}
+
+					// the panic has already happened, so it's logged as an error instead of panicking again in development
 					response := newResponse(core)
-					response.writeError(KindUnknown)
+					response.writeError(p, zapcore.ErrorLevel)
 				}
 			}()
 
@@ -163,12 +210,17 @@ func (s *server) setupRoutes() {
 		if res.result.Errors != nil {
 			// convert any errors to Error
 			for _, err := range res.result.Errors {
+				if p := core.recoveredPanic(err); p != nil {
+					// the resolver panicked, which graphql-go recovered from
+					err.ResolverError = NewError(core, p, zapcore.ErrorLevel)
+				}
 				if err.ResolverError != nil {
-					e := NewError(core, err.ResolverError)
+					e := NewError(core, err.ResolverError).localized()
 					err.Extensions = e.Extensions()
 					err.Message = e.Error()
 					err.ResolverError = e
 					res.status = e.HttpStatus()
+					res.addRetryAfter(e)
 				} else {
 					// an error occurred before the resolver was called
 					// most likely a query validation error
@@ -228,6 +280,20 @@ func (s *server) setupRoutes() {
 		execute(core, req, res)
 	})
 
+	if s.config.CoreConfig().Server.Oidc != nil {
+		s.oidc = newOidcProviders(s.config.CoreConfig().Server.Oidc)
+		s.router.Get("/auth/{provider}/login", s.oidcLogin)
+		s.router.Get("/auth/{provider}/callback", s.oidcCallbackHandler)
+	}
+
+	if path := s.config.CoreConfig().Server.ErrorCatalogPath; path != "" {
+		s.router.Get(path, serveErrorCatalog)
+	}
+
+	if s.config.CoreConfig().Server.TokenExchange != nil {
+		s.router.Post("/token", s.tokenExchange)
+	}
+
 	s.router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
 		core, err := s.newCore(w, r, "server.MethodNotAllowed")
 		response := newResponse(core)
@@ -249,6 +315,19 @@ func (s *server) setupRoutes() {
 	})
 }
 
+// warnUntrustedForwardedFor logs a warning the first time a request sends X-Forwarded-For while server.trusted_proxies
+// isn't configured. The server is probably behind a proxy, so every client would get the proxy's IP address.
+func (s *server) warnUntrustedForwardedFor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.config.CoreConfig().Server.TrustedProxies) == 0 && r.Header.Get("X-Forwarded-For") != "" {
+			s.untrustedForwardedFor.Do(func() {
+				s.logger.Warn("ignoring X-Forwarded-For because server.trusted_proxies isn't configured, so clients behind a proxy share the proxy's IP address (e.g. in login attempt lockouts)", "config", s.config)
+			})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // ServeHTTP
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
@@ -263,6 +342,46 @@ func Run(opts Options) {
 	if detail == nil {
 		logger.Fatal("ErrorDetailer must not be nil", "config", opts.Config)
 	}
+	if err := RegisterErrorKinds(opts.ErrorKinds...); err != nil {
+		logger.Fatal("invalid ErrorKinds", "error", err)
+	}
+	if err := setupLocales(opts.Config.CoreConfig().Server.Locale); err != nil {
+		logger.Fatal("failed to load translations", "error", err, "config", opts.Config)
+	}
+	localeHook = opts.LocaleHook
+	if err := RegisterValidations(opts); err != nil {
+		logger.Fatal("failed to register validations", "error", err)
+	}
+
+	if opts.RefreshTokenStore != nil {
+		refreshTokens = opts.RefreshTokenStore
+	}
+	if opts.AccessTokenDenylist != nil {
+		denylist = opts.AccessTokenDenylist
+	}
+	if opts.SessionStore != nil {
+		sessions = opts.SessionStore
+	}
+	if opts.ApiKeyStore != nil {
+		apiKeys = opts.ApiKeyStore
+	}
+	if opts.PasswordHasher != nil {
+		passwords = opts.PasswordHasher
+	}
+	if opts.UsedTokenStore != nil {
+		usedTokens = opts.UsedTokenStore
+	}
+	if opts.LoginAttemptStore != nil {
+		loginAttempts = opts.LoginAttemptStore
+	}
+	loginLockoutHook = opts.LoginLockoutHook
+	auditSinks = opts.AuditSinks
+	if opts.ErrorReporter != nil {
+		errorReports = newErrorReportQueue(opts.ErrorReporter, errorReportQueueSize)
+	}
+	visitorMergeHook = opts.VisitorMergeHook
+	roles = opts.Roles
+	impersonationHook = opts.ImpersonationHook
 
 	s := &server{
 		logger:   logger,
@@ -271,6 +390,8 @@ func Run(opts Options) {
 		decorate: opts.ContextDecorator,
 		router:   chi.NewRouter(),
 
+		oidcCallback: opts.OidcCallback,
+
 		// set later
 		db:     nil,
 		schema: nil,
@@ -282,6 +403,15 @@ func Run(opts Options) {
 	if s.decorate == nil {
 		logger.Fatal("ResolverContextDecorator must not be nil", "config", opts.Config)
 	}
+	if s.config.CoreConfig().Server.Oidc != nil && s.oidcCallback == nil {
+		logger.Fatal("OidcCallback must not be nil when server.oidc is configured", "config", opts.Config)
+	}
+	if jwtCfg := s.config.CoreConfig().Server.Jwt; jwtCfg.AutoRefresh {
+		if jwtCfg.RefreshToken == nil || jwtCfg.AccessTokenTransport == nil ||
+			(jwtCfg.AccessTokenTransport.Cookie == nil && jwtCfg.AccessTokenTransport.Header == "") {
+			logger.Fatal("server.jwt.auto_refresh requires server.jwt.refresh_token and an access_token_transport cookie or header", "config", opts.Config)
+		}
+	}
 
 	if s.config.CoreConfig().Database.Main.Driver != "" {
 		db, err := sql.Open(s.config.CoreConfig().Database.Main.Driver, s.config.CoreConfig().Database.Main.DataSourceName())
@@ -310,7 +440,7 @@ func Run(opts Options) {
 		s.logger.Fatal("failed to read graphql schema", "error", err, "config", s.config)
 	}
 
-	s.schema, err = graphql.ParseSchema(string(bytes), s.resolver)
+	s.schema, err = graphql.ParseSchema(string(bytes), s.resolver, graphqlSchemaOptions(s.logger)...)
 	if err != nil {
 		s.logger.Fatal("failed to parse graphql schema", "error", err, "config", s.config)
 	}
@@ -319,6 +449,9 @@ func Run(opts Options) {
 
 	// cleanup server resources
 	defer func() {
+		if errorReports != nil && !errorReports.shutdown(shutdownTimeout) {
+			s.logger.Warn("failed to send the queued error reports before shutting down")
+		}
 		if s.db != nil {
 			if err := s.db.Close(); err != nil {
 				s.logger.Error("failed to close database", "error", err)
@@ -331,10 +464,28 @@ func Run(opts Options) {
 		}
 	}()
 
+	// shut down gracefully on SIGINT and SIGTERM, so in-flight requests finish and queued error reports are sent
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", s.config.CoreConfig().Server.Port), Handler: s}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		s.logger.Info("shutting down", "config", s.config)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			s.logger.Error("failed to shut down server gracefully", "error", err)
+		}
+	}()
+
 	s.logger.Info(fmt.Sprintf("listening on port %d", s.config.CoreConfig().Server.Port), "config", s.config)
-	if err = http.ListenAndServe(fmt.Sprintf(":%d", s.config.CoreConfig().Server.Port), s); err != nil {
+	if err = srv.ListenAndServe(); err != http.ErrServerClosed {
 		logger.Fatal("failed to run server", "error", err)
 	}
+	<-stopped
 }
 
 // Options
@@ -343,6 +494,53 @@ type Options struct {
 	ErrorDecorator   ErrorDetailer
 	ContextDecorator ResolverContextDecorator
 	Resolver         interface{}
+	// RefreshTokenStore is used to rotate and revoke refresh tokens. Defaults to core.NewMemoryRefreshTokenStore().
+	RefreshTokenStore RefreshTokenStore
+	// AccessTokenDenylist is used to reject access tokens that were revoked before they expired.
+	// Defaults to core.NewMemoryAccessTokenDenylist().
+	AccessTokenDenylist AccessTokenDenylist
+	// SessionStore is used to store sessions when server.session.mode is "opaque".
+	// Defaults to core.NewMemorySessionStore().
+	SessionStore SessionStore
+	// ApiKeyStore is used to authenticate API keys. Defaults to core.NewMemoryApiKeyStore().
+	ApiKeyStore ApiKeyStore
+	// OidcCallback maps identities from OpenID Connect providers to local subjects.
+	// It's required if server.oidc is configured.
+	OidcCallback OidcCallback
+	// Roles returns the roles that are added to a subject's access tokens. Optional.
+	Roles RolesFunc
+	// ImpersonationHook is called whenever an impersonating session is used. Optional.
+	ImpersonationHook ImpersonationHook
+	// PasswordHasher is used to hash new passwords. Hashes made by other hashers are upgraded when they're verified.
+	// Defaults to core.NewBcryptHasher(bcrypt.DefaultCost).
+	PasswordHasher PasswordHasher
+	// LoginAttemptStore is used to throttle failed logins. Defaults to core.NewMemoryLoginAttemptStore().
+	LoginAttemptStore LoginAttemptStore
+	// LoginLockoutHook is called when an identifier or IP address gets locked out. Optional.
+	LoginLockoutHook LoginLockoutHook
+	// VisitorMergeHook is called when a visitor logs in, so their anonymous state can be merged into the subject's.
+	// Optional.
+	VisitorMergeHook VisitorMergeHook
+	// AuditSinks record audit events (see core.Core.Audit). If there aren't any, audit events aren't recorded.
+	AuditSinks []AuditSink
+	// UsedTokenStore is used to prevent signed tokens from being used twice. Defaults to core.NewMemoryUsedTokenStore().
+	UsedTokenStore UsedTokenStore
+	// LocaleHook chooses the locale of a request instead of the Accept-Language header (e.g. from the subject's
+	// settings). Optional.
+	LocaleHook LocaleHook
+	// Validations are custom validation rules that can be used in validate tags. Optional.
+	Validations []Validation
+	// StructValidations are custom validation rules for whole structs. Optional.
+	StructValidations []StructValidation
+	// ValidationMessages are messages of validation rules keyed by tag and then locale (e.g.
+	// {"passwords_match": {"en": "{0} must match the password"}}). They replace the built in messages of a rule, and
+	// give messages to the errors reported by StructValidations. Optional.
+	ValidationMessages map[string]map[string]string
+	// ErrorReporter is sent every error with a severity of error or higher (e.g. core.NewSentryReporter). Optional.
+	ErrorReporter ErrorReporter
+	// ErrorKinds are the application's own kinds of errors. They're added to the error catalog, and the server won't
+	// start if one of their codes collides with another kind or doesn't start with a valid HTTP status.
+	ErrorKinds []ErrorKind
 }
 
 // ResolverContextDecorator
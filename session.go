@@ -1,6 +1,8 @@
 package core
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -8,6 +10,8 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	nanoid "github.com/matoous/go-nanoid"
+	"go.uber.org/zap/zapcore"
 )
 
 const (
@@ -15,9 +19,23 @@ const (
 	refreshTokenKey = "refresh_token"
 )
 
+const (
+	// AccessTokenFromHeader searches for the access token in the Authorization header (Bearer).
+	AccessTokenFromHeader = "header"
+	// AccessTokenFromQuery searches for the access token in the access_token query parameter.
+	AccessTokenFromQuery = "query"
+	// AccessTokenFromCookie searches for the access token in the access_token cookie.
+	AccessTokenFromCookie = "cookie"
+)
+
+// defaultAccessTokenPrecedence is used when server.jwt.access_token_transport.precedence isn't configured.
+var defaultAccessTokenPrecedence = []string{AccessTokenFromHeader, AccessTokenFromQuery, AccessTokenFromCookie}
+
 // Session
 type Session interface {
-	// IsLoggedIn
+	// IsLoggedIn reports whether a subject is fully logged in. Sessions authenticated with an API key never are, see
+	// core.Core.RequireScope, and neither are sessions that are waiting for a second factor, see
+	// IsPartiallyAuthenticated.
 	IsLoggedIn() bool
 	// IsAnonymous
 	IsAnonymous() bool
@@ -25,12 +43,85 @@ type Session interface {
 	AccessToken() string
 	// RefreshAccessToken
 	RefreshAccessToken() bool
-	// UserId
+	// UserId returns the subject of the session as an int. Use Subject if your user ids aren't integers.
 	UserId() int
-	// Login
+	// Subject returns the subject of the session, or an empty string if the session is anonymous.
+	// Sessions authenticated with an API key return the key's subject, even though they aren't logged in.
+	Subject() string
+	// ServiceId returns the id of the service that is acting on behalf of the subject, or an empty string if the
+	// subject is acting on their own. See server.token_exchange.
+	ServiceId() string
+	// HasScope reports whether the session is allowed to use the given scope.
+	// Sessions authenticated with an API key are limited to the key's scopes, logged in sessions aren't limited.
+	HasScope(string) bool
+	// Login is a shorthand for LoginSubject(strconv.Itoa(userId)).
 	Login(int)
-	// Logout
+	// LoginSubject logs in the given subject (e.g. a UUID or nanoid user id).
+	LoginSubject(string)
+	// Logout revokes the current refresh token (and every token rotated from it) and removes it from the client.
 	Logout()
+	// LogoutEverywhere revokes every access token, refresh token and API key that belongs to the current subject.
+	LogoutEverywhere()
+	// Revoke revokes the current access token and logs out.
+	Revoke()
+	// HasRole reports whether the subject has the given role. See core.Options.Roles.
+	HasRole(string) bool
+	// Impersonate is a shorthand for ImpersonateSubject(strconv.Itoa(userId)).
+	Impersonate(int) error
+	// ImpersonateSubject makes the session act as the given subject. The session must have the role given by
+	// server.impersonation.role. While impersonating, UserId and Subject return the impersonated subject.
+	ImpersonateSubject(string) error
+	// StopImpersonating makes the session act as the real subject again.
+	StopImpersonating()
+	// IsImpersonating reports whether the session is impersonating another subject.
+	IsImpersonating() bool
+	// RealSubject returns the subject that is actually logged in, even while impersonating.
+	RealSubject() string
+	// LoginPartially logs in the given subject, but marks the session as waiting for a second factor.
+	// See core.Core.RequireSecondFactor.
+	LoginPartially(string)
+	// IsPartiallyAuthenticated reports whether the session is waiting for a second factor.
+	IsPartiallyAuthenticated() bool
+	// CompleteSecondFactor fully logs in a partially authenticated session once its second factor is verified.
+	CompleteSecondFactor()
+	// Sessions returns the devices the current subject is logged in on.
+	Sessions() ([]DeviceSession, error)
+	// RevokeSession logs the current subject out of the device session with the given id.
+	RevokeSession(id string) error
+	// VisitorId returns a stable id for the browser that made the request, whether or not it's logged in. The id is
+	// kept in a signed cookie that's set the first time it's needed. It's empty if server.visitor isn't configured.
+	VisitorId() string
+}
+
+// tokenClaims are the claims of access and refresh tokens.
+type tokenClaims struct {
+	jwt.StandardClaims
+	// Act identifies the service that is acting on behalf of the subject (RFC 8693).
+	Act *actorClaim `json:"act,omitempty"`
+	// Imp identifies the real subject when the subject is being impersonated.
+	Imp *actorClaim `json:"imp,omitempty"`
+	// Roles are the roles of the subject.
+	Roles []string `json:"roles,omitempty"`
+	// Sid is the id of the device session the access token was issued to. See core.DeviceSession.
+	Sid string `json:"sid,omitempty"`
+	// SecondFactorPending indicates the subject still needs to verify their second factor.
+	SecondFactorPending bool `json:"mfa_pending,omitempty"`
+	// IssuedAtNano is when the token was issued in nanoseconds, so it can be revoked with sub-second precision.
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
+}
+
+// issuedAt returns when the token was issued. Tokens issued without the "iat_ns" claim only know the second they were
+// issued in.
+func (c *tokenClaims) issuedAt() time.Time {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// actorClaim
+type actorClaim struct {
+	Subject string `json:"sub"`
 }
 
 // session
@@ -45,11 +136,30 @@ type session struct {
 // Access Tokens are searched for in multiple places, with some places having a higher priority than
 // others (in-case an access token exists in more than one place).
 //
-// The order of precedence from highest to lowest is:
+// The default order of precedence from highest to lowest is:
 // - Authorization header (Bearer)
 // - Query parameter (access_token)
 // - Cookie (access_token)
+//
+// The order can be changed, and places can be left out, with server.jwt.access_token_transport.precedence.
+//
+// If server.jwt.auto_refresh is enabled and the access token has expired, the refresh token cookie is used to issue a
+// new access token instead of returning a KindExpiredAccessToken error.
+//
+// If server.session.mode is "opaque", the access token is an opaque session id instead of a JWT.
+//
+// API keys take precedence over access tokens and are searched for in the following places:
+// - X-Api-Key header
+// - Authorization header (ApiKey)
 func (c *Core) StartSession() error {
+	if apiKey := getApiKeyString(c); apiKey != "" {
+		return startApiKeySession(c, apiKey)
+	}
+
+	if isOpaqueSessionMode(c) {
+		return startOpaqueSession(c)
+	}
+
 	c.Session = &session{core: c}
 	accessTokenString, err := getAccessTokenString(c)
 	if err != nil {
@@ -58,18 +168,57 @@ func (c *Core) StartSession() error {
 
 	if accessTokenString != "" {
 		accessToken, err := parseToken(c, accessTokenString, false)
+		if err != nil {
+			if isExpired(err) && autoRefresh(c) {
+				return nil
+			}
+			return err
+		}
+
+		claims := accessToken.Claims.(*tokenClaims)
+		err = checkDenylist(c, claims)
 		if err != nil {
 			return err
 		}
 
 		c.Session = &session{core: c, accessToken: accessToken, accessTokenString: accessTokenString}
+		if claims.Imp != nil && impersonationHook != nil {
+			impersonationHook(c, claims.Imp.Subject, claims.Subject)
+		}
 	}
 
 	return nil
 }
 
-// IsLoggedIn
+// isExpired reports whether the error was returned because a token has expired.
+func isExpired(err error) bool {
+	var validationErr *jwt.ValidationError
+	return errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0
+}
+
+// autoRefresh refreshes the access token of the request's session if server.jwt.auto_refresh is enabled.
+// The new access token is sent to the client through server.jwt.access_token_transport.
+func autoRefresh(c *Core) bool {
+	if !c.Config.CoreConfig().Server.Jwt.AutoRefresh || c.Config.CoreConfig().Server.Jwt.RefreshToken == nil {
+		return false
+	}
+
+	c.Session = &session{core: c}
+	if !c.Session.RefreshAccessToken() {
+		return false
+	}
+
+	c.Logger.Debug("refreshed expired access token")
+	return true
+}
+
+// IsLoggedIn returns false while the session is waiting for a second factor.
 func (s *session) IsLoggedIn() bool {
+	return s.hasAccessToken() && !s.accessToken.Claims.(*tokenClaims).SecondFactorPending
+}
+
+// hasAccessToken reports whether the session has a valid access token, even if it's waiting for a second factor.
+func (s *session) hasAccessToken() bool {
 	return s.accessToken != nil && s.accessToken.Valid
 }
 
@@ -80,7 +229,7 @@ func (s *session) IsAnonymous() bool {
 
 // AccessToken
 func (s *session) AccessToken() string {
-	if s.IsAnonymous() {
+	if !s.hasAccessToken() {
 		return ""
 	}
 
@@ -95,71 +244,223 @@ func (s *session) AccessToken() string {
 	return s.accessTokenString
 }
 
-// RefreshAccessToken
+// RefreshAccessToken rotates the refresh token and issues a new access token.
+//
+// If a refresh token that has already been rotated is used again, every refresh token in its family is revoked.
+// Reuse within server.jwt.refresh_token_reuse_interval is allowed so that concurrent requests don't log the user out.
 func (s *session) RefreshAccessToken() bool {
-	refreshToken := getRefreshToken(s.core)
-	if refreshToken == nil {
+	record := findRefreshToken(s.core)
+	if record == nil {
+		return false
+	}
+
+	if record.RevokedAt != nil {
+		if isConcurrentRefresh(s.core, record) {
+			s.core.Logger.Debug("refresh token was rotated by a concurrent request", "refreshTokenId", record.Id)
+			s.issue(generateSessionToken(s.core, record))
+			return true
+		}
+
+		s.core.Logger.Warn("refresh token reuse detected, revoking token family", "refreshTokenId", record.Id, "family", record.Family)
+		s.core.Audit(AuditRefreshTokenReused, record.Subject, map[string]interface{}{"family": record.Family})
+		revokeRefreshTokens(s.core, record)
 		return false
 	}
 
-	userId, err := strconv.Atoi(refreshToken.Claims.(*jwt.StandardClaims).Subject)
+	refreshToken, next := newRefreshToken(s.core, record.Subject, record.Family)
+	err := refreshTokens.Rotate(s.core, record.Id, next)
 	if err != nil {
-		setRefreshToken(s.core, nil)
-		s.core.Logger.DPanic("could not parse refresh token's subject to an int")
+		if err == ErrRefreshTokenReused {
+			s.core.Logger.Warn("refresh token reuse detected, revoking token family", "refreshTokenId", record.Id, "family", record.Family)
+			s.core.Audit(AuditRefreshTokenReused, record.Subject, map[string]interface{}{"family": record.Family})
+			revokeRefreshTokens(s.core, record)
+		} else {
+			s.core.Logger.Error("failed to rotate refresh token", "error", err, "refreshTokenId", record.Id)
+		}
+		return false
 	}
 
-	*s = session{core: s.core, accessToken: generateToken(s.core, userId, false)}
+	setRefreshToken(s.core, refreshToken)
+	s.issue(generateSessionToken(s.core, next))
+	s.core.Audit(AuditRefresh, record.Subject, map[string]interface{}{"family": record.Family})
 	return true
 }
 
 // UserId
 func (s *session) UserId() int {
-	if s.IsAnonymous() {
+	if !s.hasAccessToken() {
 		return 0
 	}
-	id, err := strconv.Atoi(s.accessToken.Claims.(*jwt.StandardClaims).Subject)
+	id, err := strconv.Atoi(s.Subject())
 	if err != nil {
 		s.core.Logger.DPanic("could not parse access token's subject to an int", "error", err)
 	}
 	return id
 }
 
+// Subject also returns the subject of sessions that are waiting for a second factor, so it can be verified.
+func (s *session) Subject() string {
+	if !s.hasAccessToken() {
+		return ""
+	}
+	return s.accessToken.Claims.(*tokenClaims).Subject
+}
+
+// ServiceId
+func (s *session) ServiceId() string {
+	if !s.hasAccessToken() {
+		return ""
+	}
+	if act := s.accessToken.Claims.(*tokenClaims).Act; act != nil {
+		return act.Subject
+	}
+	return ""
+}
+
+// HasScope
+func (s *session) HasScope(string) bool {
+	return s.IsLoggedIn()
+}
+
 // Login
 func (s *session) Login(userId int) {
+	s.LoginSubject(strconv.Itoa(userId))
+}
+
+// LoginSubject
+func (s *session) LoginSubject(subject string) {
 	if s.core.Config.CoreConfig().Server.Jwt.RefreshToken != nil {
-		refreshToken := generateToken(s.core, userId, true)
-		setRefreshToken(s.core, refreshToken)
+		refreshToken, record := newRefreshToken(s.core, subject, "")
+		if err := refreshTokens.Create(s.core, record); err != nil {
+			s.core.Logger.Error("failed to store refresh token", "error", err, "refreshTokenId", record.Id)
+		} else {
+			setRefreshToken(s.core, refreshToken)
+			s.issue(generateSessionToken(s.core, record))
+			s.core.Audit(AuditLogin, subject, map[string]interface{}{"family": record.Family, "device": record.Device})
+			mergeVisitor(s.core, subject)
+			return
+		}
 	}
-	*s = session{core: s.core, accessToken: generateToken(s.core, userId, false)}
+	s.issue(generateToken(s.core, subject, false))
+	s.core.Audit(AuditLogin, subject, nil)
+	mergeVisitor(s.core, subject)
 }
 
 // Logout
 func (s *session) Logout() {
+	if record := findRefreshToken(s.core); record != nil {
+		revokeRefreshTokens(s.core, record)
+		s.core.Audit(AuditLogout, record.Subject, map[string]interface{}{"family": record.Family})
+	}
 	setRefreshToken(s.core, nil)
+	setAccessToken(s.core, nil)
 }
 
-func getAccessTokenString(core *Core) (string, error) {
-	accessTokenCookie, err := core.Request.Cookie(accessTokenKey)
-	if err == nil {
-		core.Logger.Debug("using access token within cookie", "accessToken", accessTokenCookie.Value)
-		return accessTokenCookie.Value, nil
+// LogoutEverywhere logs out the real subject everywhere, even while impersonating.
+func (s *session) LogoutEverywhere() {
+	subject := s.RealSubject()
+	if subject == "" {
+		if record := findRefreshToken(s.core); record != nil {
+			subject = record.Subject
+		}
 	}
 
-	auth := core.Request.Header.Get("Authorization")
-	if auth != "" {
-		if !strings.HasPrefix(auth, "Bearer ") || len(auth) < 8 {
-			return "", NewError(core, KindInvalidJwt, "Authorization header must begin with 'Bearer' followed by the access token. (eg 'Bearer {access_token}')")
+	if subject != "" {
+		if err := s.core.RevokeSubject(subject, time.Now()); err != nil {
+			s.core.Logger.Error("failed to revoke subject", "error", err, "subject", subject)
 		}
-		core.Logger.Debug("using access token within authorization header", "accessToken", auth[7:])
-		return auth[7:], nil
+		s.core.Audit(AuditLogoutEverywhere, subject, nil)
 	}
+	setRefreshToken(s.core, nil)
+	setAccessToken(s.core, nil)
+	*s = session{core: s.core}
+}
 
-	token := core.Request.URL.Query().Get(accessTokenKey)
-	if token != "" {
-		core.Logger.Debug("using access token within query parameter")
+// Revoke
+func (s *session) Revoke() {
+	if s.hasAccessToken() {
+		claims := s.accessToken.Claims.(*tokenClaims)
+		if err := s.core.RevokeAccessToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			s.core.Logger.Error("failed to revoke access token", "error", err, "accessTokenId", claims.Id)
+		}
 	}
+	s.Logout()
+	*s = session{core: s.core}
+}
 
-	return token, nil
+// issue replaces the session's access token and sends it to the client through the configured transports.
+func (s *session) issue(accessToken *jwt.Token) {
+	*s = session{core: s.core, accessToken: accessToken}
+	setAccessToken(s.core, accessToken)
+}
+
+// MarshalLogObject is used to implement zapcore.ObjectMarshaler interface.
+func (s *session) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("token", s.accessTokenString)
+	if s.accessToken != nil {
+		_ = enc.AddObject("claims", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			claims := s.accessToken.Claims.(*tokenClaims)
+			enc.AddString("aud", claims.Audience)
+			enc.AddInt64("exp", claims.ExpiresAt)
+			enc.AddString("jti", claims.Id)
+			enc.AddInt64("iat", claims.IssuedAt)
+			enc.AddString("iss", claims.Issuer)
+			enc.AddInt64("nbf", claims.NotBefore)
+			enc.AddString("sub", claims.Subject)
+			if claims.Act != nil {
+				enc.AddString("act", claims.Act.Subject)
+			}
+			if claims.Imp != nil {
+				enc.AddString("imp", claims.Imp.Subject)
+			}
+			if len(claims.Roles) > 0 {
+				enc.AddString("roles", strings.Join(claims.Roles, ","))
+			}
+			if claims.Sid != "" {
+				enc.AddString("sid", claims.Sid)
+			}
+			if claims.SecondFactorPending {
+				enc.AddBool("mfa_pending", true)
+			}
+			return nil
+		}))
+	}
+	return nil
+}
+
+func getAccessTokenString(core *Core) (string, error) {
+	precedence := defaultAccessTokenPrecedence
+	if transport := core.Config.CoreConfig().Server.Jwt.AccessTokenTransport; transport != nil && len(transport.Precedence) > 0 {
+		precedence = transport.Precedence
+	}
+
+	for _, from := range precedence {
+		switch from {
+		case AccessTokenFromHeader:
+			auth := core.Request.Header.Get("Authorization")
+			if auth != "" {
+				if !strings.HasPrefix(auth, "Bearer ") || len(auth) < 8 {
+					return "", NewError(core, KindInvalidJwt, "Authorization header must begin with 'Bearer' followed by the access token. (eg 'Bearer {access_token}')")
+				}
+				core.Logger.Debug("using access token within authorization header", "accessToken", auth[7:])
+				return auth[7:], nil
+			}
+		case AccessTokenFromQuery:
+			token := core.Request.URL.Query().Get(accessTokenKey)
+			if token != "" {
+				core.Logger.Debug("using access token within query parameter")
+				return token, nil
+			}
+		case AccessTokenFromCookie:
+			accessTokenCookie, err := core.Request.Cookie(accessTokenKey)
+			if err == nil && accessTokenCookie.Value != "" {
+				core.Logger.Debug("using access token within cookie", "accessToken", accessTokenCookie.Value)
+				return accessTokenCookie.Value, nil
+			}
+		}
+	}
+
+	return "", nil
 }
 
 func getRefreshToken(core *Core) *jwt.Token {
@@ -178,22 +479,103 @@ func getRefreshToken(core *Core) *jwt.Token {
 	return refreshToken
 }
 
-func generateToken(core *Core, userId int, isRefreshToken bool) *jwt.Token {
+// findRefreshToken returns the stored record of the refresh token within the request's cookie.
+// If the cookie contains an invalid or unknown refresh token, it's removed.
+func findRefreshToken(core *Core) *RefreshToken {
+	refreshToken := getRefreshToken(core)
+	if refreshToken == nil {
+		return nil
+	}
+
+	id := refreshToken.Claims.(*tokenClaims).Id
+	record, err := refreshTokens.Find(core, id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			core.Logger.Error("failed to find refresh token", "error", err, "refreshTokenId", id)
+		}
+		setRefreshToken(core, nil)
+		return nil
+	}
+
+	return record
+}
+
+// isConcurrentRefresh reports whether a revoked refresh token was rotated recently enough that its reuse is most
+// likely a concurrent request rather than a stolen token.
+func isConcurrentRefresh(core *Core, record *RefreshToken) bool {
+	interval := core.Config.CoreConfig().Server.Jwt.RefreshTokenReuseInterval
+	if record.ReplacedBy == "" || time.Since(*record.RevokedAt) > interval {
+		return false
+	}
+
+	next, err := refreshTokens.Find(core, record.ReplacedBy)
+	return err == nil && next.RevokedAt == nil
+}
+
+// revokeRefreshTokens revokes the family of the given refresh token and removes it from the client.
+func revokeRefreshTokens(core *Core, record *RefreshToken) {
+	if err := refreshTokens.RevokeFamily(core, record.Family); err != nil {
+		core.Logger.Error("failed to revoke refresh token family", "error", err, "family", record.Family)
+	}
+	setRefreshToken(core, nil)
+}
+
+// newRefreshToken generates a refresh token and the record that should be stored for it.
+// If family is empty, the refresh token starts a new family.
+func newRefreshToken(core *Core, subject, family string) (*jwt.Token, *RefreshToken) {
+	token := generateToken(core, subject, true)
+	claims := token.Claims.(*tokenClaims)
+	if family == "" {
+		family = claims.Id
+	}
+
+	record := &RefreshToken{
+		Id:        claims.Id,
+		Family:    family,
+		Subject:   subject,
+		IssuedAt:  time.Unix(claims.IssuedAt, 0),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	if core.Request != nil {
+		record.Ip = core.remoteIp()
+		record.UserAgent = core.Request.UserAgent()
+		record.Device = deviceName(record.UserAgent)
+	}
+	return token, record
+}
+
+// generateSessionToken generates an access token for the device session of the given refresh token.
+func generateSessionToken(core *Core, record *RefreshToken) *jwt.Token {
+	token := generateToken(core, record.Subject, false)
+	token.Claims.(*tokenClaims).Sid = record.Family
+	return token
+}
+
+func generateToken(core *Core, subject string, isRefreshToken bool) *jwt.Token {
 	cfg := core.Config.CoreConfig().Server.Jwt.AccessToken
 	if isRefreshToken {
 		cfg = *core.Config.CoreConfig().Server.Jwt.RefreshToken
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
-		Id:        core.Id,
+	// tokens are revoked by id, so every token needs its own
+	// this should never error
+	id, _ := nanoid.Nanoid()
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{StandardClaims: jwt.StandardClaims{
+		Id:        id,
 		Audience:  strings.Join(cfg.Audience, ","),
-		ExpiresAt: time.Now().Add(cfg.ExpiresAt).Unix(),
-		IssuedAt:  time.Now().Unix(), Issuer: cfg.Issuer,
-		NotBefore: time.Now().Add(cfg.NotBefore).Unix(),
-		Subject:   strconv.Itoa(userId),
-	})
+		ExpiresAt: now.Add(cfg.ExpiresAt).Unix(),
+		IssuedAt:  now.Unix(), Issuer: cfg.Issuer,
+		NotBefore: now.Add(cfg.NotBefore).Unix(),
+		Subject:   subject,
+	}, IssuedAtNano: now.UnixNano()})
 	token.Valid = true
 
+	if !isRefreshToken {
+		token.Claims.(*tokenClaims).Roles = subjectRoles(core, subject)
+	}
+
 	if isRefreshToken {
 		core.Logger.Debug("generated refresh token", "token", token)
 	} else {
@@ -212,7 +594,7 @@ func parseToken(core *Core, token string, isRefreshToken bool) (*jwt.Token, erro
 	}
 
 	core.Logger.Debug(msg, "token", token)
-	return jwt.ParseWithClaims(token, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+	return jwt.ParseWithClaims(token, &tokenClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.NewValidationError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]), jwt.ValidationErrorSignatureInvalid)
 		}
@@ -234,24 +616,75 @@ func setRefreshToken(core *Core, refreshToken *jwt.Token) {
 		}
 	}
 
-	sameSite := http.SameSiteDefaultMode
-	switch core.Config.CoreConfig().Server.Jwt.RefreshCookie.SameSite {
-	case "none":
-		sameSite = http.SameSiteNoneMode
-	case "lax":
-		sameSite = http.SameSiteLaxMode
-	case "strict":
-		sameSite = http.SameSiteStrictMode
-	}
-
 	http.SetCookie(core.w, &http.Cookie{
 		Name:     refreshTokenKey,
 		Value:    refreshTokenString,
 		MaxAge:   maxAge,
-		SameSite: sameSite,
+		SameSite: sameSiteMode(core.Config.CoreConfig().Server.Jwt.RefreshCookie.SameSite),
 		Domain:   core.Config.CoreConfig().Server.Jwt.RefreshCookie.Domain,
 		Path:     core.Config.CoreConfig().Server.Jwt.RefreshCookie.Path,
 		Secure:   core.Config.CoreConfig().Server.Jwt.RefreshCookie.Secure,
 		HttpOnly: core.Config.CoreConfig().Server.Jwt.RefreshCookie.HttpOnly,
 	})
 }
+
+// setAccessToken sends the access token to the client through the transports configured in
+// server.jwt.access_token_transport. If accessToken is nil, the access token cookie is removed.
+func setAccessToken(core *Core, accessToken *jwt.Token) {
+	transport := core.Config.CoreConfig().Server.Jwt.AccessTokenTransport
+	if transport == nil || core.w == nil {
+		return
+	}
+
+	accessTokenString := ""
+	maxAge := -1
+	if accessToken != nil {
+		var err error
+		accessTokenString, err = accessToken.SignedString([]byte(core.Config.CoreConfig().Server.Jwt.AccessToken.Secret))
+		if err != nil {
+			core.Logger.DPanic("issue signing access token", "error", err, "accessToken", accessToken)
+			return
+		}
+		maxAge = int(time.Until(time.Unix(accessToken.Claims.(*tokenClaims).ExpiresAt, 0)).Seconds())
+	}
+
+	sendAccessToken(core, transport, accessTokenString, maxAge)
+}
+
+// sendAccessToken sends the access token (or opaque session id) to the client through the given transports. If
+// accessToken is empty, the access token cookie is removed.
+func sendAccessToken(core *Core, transport *AccessTokenTransportConfig, accessToken string, maxAge int) {
+	if transport.Header != "" && accessToken != "" {
+		core.w.Header().Set(transport.Header, accessToken)
+	}
+
+	if transport.Cookie != nil {
+		if accessToken == "" {
+			maxAge = -1
+		}
+		http.SetCookie(core.w, &http.Cookie{
+			Name:     accessTokenKey,
+			Value:    accessToken,
+			MaxAge:   maxAge,
+			SameSite: sameSiteMode(transport.Cookie.SameSite),
+			Domain:   transport.Cookie.Domain,
+			Path:     transport.Cookie.Path,
+			Secure:   transport.Cookie.Secure,
+			HttpOnly: true,
+		})
+	}
+}
+
+// sameSiteMode converts the same_site value of a cookie's configuration to its http.SameSite.
+func sameSiteMode(sameSite string) http.SameSite {
+	switch sameSite {
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
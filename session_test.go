@@ -0,0 +1,105 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAccessTokenString_Precedence(t *testing.T) {
+	c := newTestCore()
+	c.Request = httptest.NewRequest("GET", "/?access_token=query", nil)
+	c.Request.Header.Set("Authorization", "Bearer header")
+	c.Request.AddCookie(&http.Cookie{Name: accessTokenKey, Value: "cookie"})
+
+	token, err := getAccessTokenString(c)
+	require.NoError(t, err)
+	require.Equal(t, "header", token)
+
+	c.Config.CoreConfig().Server.Jwt.AccessTokenTransport = &AccessTokenTransportConfig{
+		Precedence: []string{AccessTokenFromCookie, AccessTokenFromHeader},
+	}
+	token, err = getAccessTokenString(c)
+	require.NoError(t, err)
+	require.Equal(t, "cookie", token)
+
+	// places that aren't listed aren't searched
+	c.Config.CoreConfig().Server.Jwt.AccessTokenTransport.Precedence = []string{AccessTokenFromQuery}
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	c.Request.Header.Set("Authorization", "Bearer header")
+	token, err = getAccessTokenString(c)
+	require.NoError(t, err)
+	require.Empty(t, token)
+}
+
+func TestSession_AccessTokenTransport(t *testing.T) {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.Jwt.AccessTokenTransport = &AccessTokenTransportConfig{
+		Cookie: &CookieConfig{Path: "/", SameSite: "strict"},
+		Header: "X-Access-Token",
+	}
+	rec := c.w.(*httptest.ResponseRecorder)
+
+	c.Session.LoginSubject("subject")
+	require.Equal(t, c.Session.AccessToken(), rec.Header().Get("X-Access-Token"))
+
+	accessTokenCookie := responseCookie(c, accessTokenKey)
+	require.NotNil(t, accessTokenCookie)
+	require.Equal(t, c.Session.AccessToken(), accessTokenCookie.Value)
+	require.True(t, accessTokenCookie.HttpOnly)
+	require.Equal(t, http.SameSiteStrictMode, accessTokenCookie.SameSite)
+
+	// logging out removes the cookie
+	rec = httptest.NewRecorder()
+	c.w = rec
+	c.Session.Revoke()
+	if cookie := responseCookie(c, accessTokenKey); cookie != nil {
+		require.Empty(t, cookie.Value)
+		require.True(t, cookie.MaxAge < 0)
+	}
+}
+
+func TestStartSession_AutoRefresh(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+
+	login := newTestCore()
+	login.Session.LoginSubject("subject")
+	refreshTokenCookie := responseCookie(login, refreshTokenKey)
+	require.NotNil(t, refreshTokenCookie)
+
+	expired := generateToken(login, "subject", false)
+	expired.Claims.(*tokenClaims).ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	expiredString, err := expired.SignedString([]byte(login.Config.CoreConfig().Server.Jwt.AccessToken.Secret))
+	require.NoError(t, err)
+
+	newRequestCore := func() *Core {
+		c := newTestCore()
+		c.Config.CoreConfig().Server.Jwt.AccessTokenTransport = &AccessTokenTransportConfig{Header: "X-Access-Token"}
+		c.Request.Header.Set("Authorization", "Bearer "+expiredString)
+		c.Request.AddCookie(refreshTokenCookie)
+		return c
+	}
+
+	// without auto refresh the expired access token fails the request
+	c := newRequestCore()
+	err = c.StartSession()
+	require.Error(t, err)
+	require.True(t, isExpired(err))
+
+	c = newRequestCore()
+	c.Config.CoreConfig().Server.Jwt.AutoRefresh = true
+	require.NoError(t, c.StartSession())
+	require.True(t, c.Session.IsLoggedIn())
+	require.Equal(t, "subject", c.Session.Subject())
+	require.Equal(t, c.Session.AccessToken(), c.w.Header().Get("X-Access-Token"))
+
+	// the refresh token was rotated, so it can't silently refresh again
+	c = newRequestCore()
+	c.Config.CoreConfig().Server.Jwt.AutoRefresh = true
+	c.Config.CoreConfig().Server.Jwt.RefreshTokenReuseInterval = 0
+	require.Error(t, c.StartSession())
+}
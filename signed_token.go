@@ -0,0 +1,232 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	nanoid "github.com/matoous/go-nanoid"
+)
+
+// TokenPurpose is what a signed token can be used for. A token can only be used for the purpose it was issued for.
+type TokenPurpose string
+
+const (
+	// TokenPurposePasswordReset is for links that let a subject choose a new password. Password reset tokens must be
+	// bound to the subject's current password hash, see NewBoundSignedToken.
+	TokenPurposePasswordReset TokenPurpose = "password_reset"
+	// TokenPurposeEmailVerification is for links that verify a subject owns their email address.
+	TokenPurposeEmailVerification TokenPurpose = "email_verification"
+	// TokenPurposeMagicLink is for links that log a subject in without a password.
+	TokenPurposeMagicLink TokenPurpose = "magic_link"
+)
+
+// ErrTokenAlreadyUsed is returned by a UsedTokenStore when a signed token is used a second time.
+var ErrTokenAlreadyUsed = errors.New("token has already been used")
+
+// UsedTokenStore remembers which signed tokens have been used so they can't be replayed.
+type UsedTokenStore interface {
+	// Use marks the token with the given id as used. It returns ErrTokenAlreadyUsed if it was already used.
+	// The token only needs to be remembered until it expires.
+	Use(c *Core, id string, expiresAt time.Time) error
+}
+
+// usedTokens is the store used to prevent signed tokens from being replayed.
+var usedTokens UsedTokenStore = NewMemoryUsedTokenStore()
+
+// signedTokenClaims
+type signedTokenClaims struct {
+	jwt.StandardClaims
+	// Binding is the HMAC of the value the token is bound to, see NewBoundSignedToken.
+	Binding string `json:"bnd,omitempty"`
+}
+
+// NewSignedToken returns a single-use token for the subject that can only be used for the given purpose. It expires
+// after the duration configured for the purpose in server.signed_tokens.
+//
+// The token is meant to be sent to the subject (usually as part of a link in an email) and passed back to
+// UseSignedToken.
+func (c *Core) NewSignedToken(purpose TokenPurpose, subject string) (string, error) {
+	if purpose == TokenPurposePasswordReset {
+		return "", errors.New("password reset tokens must be bound to the subject's password, use NewBoundSignedToken")
+	}
+	return c.newSignedToken(purpose, subject, "")
+}
+
+// NewBoundSignedToken is like NewSignedToken, but the token is only valid while the binding stays the same. Pass
+// something that changes once the token has served its purpose, like the subject's current password hash for a
+// password reset, so every other token issued before it stops working too.
+//
+// The binding isn't readable from the token. Use the token with UseBoundSignedToken.
+func (c *Core) NewBoundSignedToken(purpose TokenPurpose, subject, binding string) (string, error) {
+	if binding == "" {
+		return "", errors.New("binding must not be empty")
+	}
+	return c.newSignedToken(purpose, subject, binding)
+}
+
+// newSignedToken returns a signed token, it's bound to the binding if it isn't empty.
+func (c *Core) newSignedToken(purpose TokenPurpose, subject, binding string) (string, error) {
+	cfg := c.Config.CoreConfig().Server.SignedTokens
+	if cfg == nil {
+		return "", errors.New("server.signed_tokens must be configured to issue signed tokens")
+	}
+	ttl, err := cfg.expiresAt(purpose)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := nanoid.Nanoid()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &signedTokenClaims{StandardClaims: jwt.StandardClaims{
+		Id:        id,
+		Subject:   subject,
+		Audience:  string(purpose),
+		Issuer:    c.Config.CoreConfig().Server.Jwt.AccessToken.Issuer,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}}
+	if binding != "" {
+		claims.Binding = signedTokenBinding(cfg.Secret, binding)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.Secret))
+}
+
+// UseSignedToken verifies the token was issued for the given purpose, hasn't expired and hasn't been used before, then
+// returns its subject. Any failure returns a KindInvalidSignedToken error. Bound tokens are always rejected, use
+// UseBoundSignedToken instead.
+func (c *Core) UseSignedToken(purpose TokenPurpose, token string) (string, error) {
+	if purpose == TokenPurposePasswordReset {
+		return "", errors.New("password reset tokens must be bound to the subject's password, use UseBoundSignedToken")
+	}
+	return c.useSignedToken(purpose, token, nil)
+}
+
+// UseBoundSignedToken is like UseSignedToken for tokens issued by NewBoundSignedToken. The binding func is given the
+// token's subject and returns its current binding (e.g. the subject's current password hash), the token is rejected if
+// it changed since the token was issued.
+func (c *Core) UseBoundSignedToken(purpose TokenPurpose, token string, binding func(subject string) (string, error)) (string, error) {
+	return c.useSignedToken(purpose, token, binding)
+}
+
+// useSignedToken uses the token, it must be bound if binding isn't nil and unbound if it is.
+func (c *Core) useSignedToken(purpose TokenPurpose, token string, binding func(subject string) (string, error)) (string, error) {
+	cfg := c.Config.CoreConfig().Server.SignedTokens
+	if cfg == nil {
+		return "", errors.New("server.signed_tokens must be configured to use signed tokens")
+	}
+
+	claims := &signedTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.NewValidationError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]), jwt.ValidationErrorSignatureInvalid)
+		}
+		return []byte(cfg.Secret), nil
+	})
+	if err != nil {
+		return "", NewError(c, err, KindInvalidSignedToken)
+	}
+	if !claims.VerifyAudience(string(purpose), true) || claims.Id == "" || claims.Subject == "" {
+		return "", NewError(c, KindInvalidSignedToken)
+	}
+	if (binding == nil) != (claims.Binding == "") {
+		return "", NewError(c, KindInvalidSignedToken)
+	}
+	if binding != nil {
+		current, err := binding(claims.Subject)
+		if err != nil {
+			return "", NewError(c, err, KindInvalidSignedToken)
+		}
+		if current == "" || !hmac.Equal([]byte(claims.Binding), []byte(signedTokenBinding(cfg.Secret, current))) {
+			c.Logger.Info("signed token binding changed", "purpose", purpose, "subject", claims.Subject)
+			return "", NewError(c, KindInvalidSignedToken)
+		}
+	}
+
+	if err = usedTokens.Use(c, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		if err == ErrTokenAlreadyUsed {
+			c.Logger.Info("signed token was replayed", "purpose", purpose, "subject", claims.Subject)
+			return "", NewError(c, err, KindInvalidSignedToken)
+		}
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+// signedTokenBinding returns the HMAC of the binding, so tokens don't reveal what they're bound to.
+func signedTokenBinding(secret, binding string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(binding))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// memoryUsedTokenStore
+type memoryUsedTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// NewMemoryUsedTokenStore returns a UsedTokenStore that keeps used tokens in memory.
+// It's the default store, but used tokens are forgotten on restart and aren't shared between instances.
+func NewMemoryUsedTokenStore() UsedTokenStore {
+	return &memoryUsedTokenStore{tokens: map[string]time.Time{}}
+}
+
+// Use
+func (m *memoryUsedTokenStore) Use(_ *Core, id string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for usedId, usedExpiresAt := range m.tokens {
+		if usedExpiresAt.Before(now) {
+			delete(m.tokens, usedId)
+		}
+	}
+
+	if _, ok := m.tokens[id]; ok {
+		return ErrTokenAlreadyUsed
+	}
+	m.tokens[id] = expiresAt
+	return nil
+}
+
+// sqlUsedTokenStore
+type sqlUsedTokenStore struct{}
+
+// NewSqlUsedTokenStore returns a UsedTokenStore that keeps used tokens in the used_tokens table of *core.Core.Db.
+// Take a look at the template's migrations to see what the table should look like.
+func NewSqlUsedTokenStore() UsedTokenStore {
+	return sqlUsedTokenStore{}
+}
+
+// Use
+func (sqlUsedTokenStore) Use(c *Core, id string, expiresAt time.Time) error {
+	if _, err := c.Db.ExecContext(c.Context, "DELETE FROM used_tokens WHERE expires_at < CURRENT_TIMESTAMP"); err != nil {
+		return err
+	}
+
+	result, err := c.Db.ExecContext(c.Context,
+		"INSERT INTO used_tokens (used_token_id, expires_at) VALUES ($1, $2) ON CONFLICT (used_token_id) DO NOTHING",
+		id, expiresAt)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return ErrTokenAlreadyUsed
+	}
+	return nil
+}
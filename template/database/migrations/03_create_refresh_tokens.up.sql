@@ -0,0 +1,12 @@
+CREATE TABLE refresh_tokens (
+    refresh_token_id text        NOT NULL PRIMARY KEY,
+    family           text        NOT NULL,
+    subject          text        NOT NULL,
+    issued_at        timestamptz NOT NULL,
+    expires_at       timestamptz NOT NULL,
+    revoked_at       timestamptz,
+    replaced_by      text        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX refresh_tokens_subject_idx ON refresh_tokens (subject);
@@ -0,0 +1,9 @@
+CREATE TABLE sessions (
+    session_key  text        NOT NULL PRIMARY KEY,
+    subject      text        NOT NULL,
+    created_at   timestamptz NOT NULL,
+    last_seen_at timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL
+);
+
+CREATE INDEX sessions_subject_idx ON sessions (subject);
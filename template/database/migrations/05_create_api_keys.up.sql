@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+    api_key_id   text        NOT NULL PRIMARY KEY,
+    name         text        NOT NULL,
+    subject      text        NOT NULL,
+    scopes       text        NOT NULL DEFAULT '',
+    hash         text        NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at timestamptz,
+    revoked_at   timestamptz
+);
+
+CREATE INDEX api_keys_subject_idx ON api_keys (subject);
@@ -0,0 +1 @@
+DROP TABLE used_tokens;
\ No newline at end of file
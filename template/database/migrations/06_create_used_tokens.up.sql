@@ -0,0 +1,7 @@
+CREATE TABLE used_tokens (
+    used_token_id text        NOT NULL PRIMARY KEY,
+    used_at       timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at    timestamptz NOT NULL
+);
+
+CREATE INDEX used_tokens_expires_at_idx ON used_tokens (expires_at);
@@ -0,0 +1,5 @@
+CREATE TABLE login_attempts (
+    login_attempt_key text        NOT NULL PRIMARY KEY,
+    failures          integer     NOT NULL DEFAULT 0,
+    last_failed_at    timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+ALTER TABLE refresh_tokens
+    DROP COLUMN device,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN device     text NOT NULL DEFAULT '',
+    ADD COLUMN ip         text NOT NULL DEFAULT '',
+    ADD COLUMN user_agent text NOT NULL DEFAULT '';
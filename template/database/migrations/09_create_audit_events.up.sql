@@ -0,0 +1,13 @@
+CREATE TABLE audit_events (
+    audit_event_id text        NOT NULL PRIMARY KEY,
+    action         text        NOT NULL,
+    actor          text        NOT NULL DEFAULT '',
+    target         text        NOT NULL DEFAULT '',
+    request_id     text        NOT NULL DEFAULT '',
+    ip             text        NOT NULL DEFAULT '',
+    metadata       jsonb       NOT NULL DEFAULT 'null',
+    created_at     timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_actor_idx ON audit_events (actor);
+CREATE INDEX audit_events_action_idx ON audit_events (action);
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    DROP COLUMN totp_secret,
+    DROP COLUMN totp_step,
+    DROP COLUMN recovery_code_hashes;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN totp_secret          text   NOT NULL DEFAULT '',
+    ADD COLUMN totp_step            bigint NOT NULL DEFAULT 0,
+    ADD COLUMN recovery_code_hashes text   NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE sessions
+    DROP COLUMN second_factor_pending,
+    DROP COLUMN device,
+    DROP COLUMN ip,
+    DROP COLUMN user_agent;
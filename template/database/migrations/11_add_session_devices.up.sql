@@ -0,0 +1,5 @@
+ALTER TABLE sessions
+    ADD COLUMN second_factor_pending boolean NOT NULL DEFAULT false,
+    ADD COLUMN device                text    NOT NULL DEFAULT '',
+    ADD COLUMN ip                    text    NOT NULL DEFAULT '',
+    ADD COLUMN user_agent            text    NOT NULL DEFAULT '';
@@ -12,9 +12,15 @@ import (
 
 func main() {
 	core.Run(core.Options{
-		Config:           &core.Config{},
-		Resolver:         &resolver.Resolver{},
-		ContextDecorator: app.ContextDecorator(),
-		ErrorDecorator:   core.DefaultErrorDecorator,
+		Config:            &core.Config{},
+		Resolver:          &resolver.Resolver{},
+		ContextDecorator:  app.ContextDecorator(),
+		ErrorDecorator:    core.DefaultErrorDecorator,
+		RefreshTokenStore: core.NewSqlRefreshTokenStore(),
+		SessionStore:      core.NewSqlSessionStore(),
+		ApiKeyStore:       core.NewSqlApiKeyStore(),
+		UsedTokenStore:    core.NewSqlUsedTokenStore(),
+		LoginAttemptStore: core.NewSqlLoginAttemptStore(),
+		AuditSinks:        []core.AuditSink{core.NewSqlAuditSink()},
 	})
 }
@@ -23,44 +23,82 @@ import (
 
 // User is an object representing the database table.
 type User struct {
-	UserID       int       `boil:"user_id" json:"userID" toml:"userID" yaml:"userID"`
-	Email        string    `boil:"email" json:"email" toml:"email" yaml:"email"`
-	PasswordHash string    `boil:"password_hash" json:"passwordHash" toml:"passwordHash" yaml:"passwordHash"`
-	CreatedAt    time.Time `boil:"created_at" json:"createdAt" toml:"createdAt" yaml:"createdAt"`
-	UpdatedAt    time.Time `boil:"updated_at" json:"updatedAt" toml:"updatedAt" yaml:"updatedAt"`
+	UserID             int       `boil:"user_id" json:"userID" toml:"userID" yaml:"userID"`
+	Email              string    `boil:"email" json:"email" toml:"email" yaml:"email"`
+	PasswordHash       string    `boil:"password_hash" json:"passwordHash" toml:"passwordHash" yaml:"passwordHash"`
+	CreatedAt          time.Time `boil:"created_at" json:"createdAt" toml:"createdAt" yaml:"createdAt"`
+	UpdatedAt          time.Time `boil:"updated_at" json:"updatedAt" toml:"updatedAt" yaml:"updatedAt"`
+	TotpSecret         string    `boil:"totp_secret" json:"totpSecret" toml:"totpSecret" yaml:"totpSecret"`
+	TotpStep           int64     `boil:"totp_step" json:"totpStep" toml:"totpStep" yaml:"totpStep"`
+	RecoveryCodeHashes string    `boil:"recovery_code_hashes" json:"recoveryCodeHashes" toml:"recoveryCodeHashes" yaml:"recoveryCodeHashes"`
 
 	R *userR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L userL  `boil:"-" json:"-" toml:"-" yaml:"-"`
 }
 
 var UserColumns = struct {
-	UserID       string
-	Email        string
-	PasswordHash string
-	CreatedAt    string
-	UpdatedAt    string
+	UserID             string
+	Email              string
+	PasswordHash       string
+	CreatedAt          string
+	UpdatedAt          string
+	TotpSecret         string
+	TotpStep           string
+	RecoveryCodeHashes string
 }{
-	UserID:       "user_id",
-	Email:        "email",
-	PasswordHash: "password_hash",
-	CreatedAt:    "created_at",
-	UpdatedAt:    "updated_at",
+	UserID:             "user_id",
+	Email:              "email",
+	PasswordHash:       "password_hash",
+	CreatedAt:          "created_at",
+	UpdatedAt:          "updated_at",
+	TotpSecret:         "totp_secret",
+	TotpStep:           "totp_step",
+	RecoveryCodeHashes: "recovery_code_hashes",
 }
 
 // Generated where
 
+type whereHelperint64 struct{ field string }
+
+func (w whereHelperint64) EQ(x int64) qm.QueryMod  { return qmhelper.Where(w.field, qmhelper.EQ, x) }
+func (w whereHelperint64) NEQ(x int64) qm.QueryMod { return qmhelper.Where(w.field, qmhelper.NEQ, x) }
+func (w whereHelperint64) LT(x int64) qm.QueryMod  { return qmhelper.Where(w.field, qmhelper.LT, x) }
+func (w whereHelperint64) LTE(x int64) qm.QueryMod { return qmhelper.Where(w.field, qmhelper.LTE, x) }
+func (w whereHelperint64) GT(x int64) qm.QueryMod  { return qmhelper.Where(w.field, qmhelper.GT, x) }
+func (w whereHelperint64) GTE(x int64) qm.QueryMod { return qmhelper.Where(w.field, qmhelper.GTE, x) }
+func (w whereHelperint64) IN(slice []int64) qm.QueryMod {
+	values := make([]interface{}, 0, len(slice))
+	for _, value := range slice {
+		values = append(values, value)
+	}
+	return qm.WhereIn(fmt.Sprintf("%s IN ?", w.field), values...)
+}
+func (w whereHelperint64) NIN(slice []int64) qm.QueryMod {
+	values := make([]interface{}, 0, len(slice))
+	for _, value := range slice {
+		values = append(values, value)
+	}
+	return qm.WhereIn(fmt.Sprintf("%s NOT IN ?", w.field), values...)
+}
+
 var UserWhere = struct {
-	UserID       whereHelperint
-	Email        whereHelperstring
-	PasswordHash whereHelperstring
-	CreatedAt    whereHelpertime_Time
-	UpdatedAt    whereHelpertime_Time
+	UserID             whereHelperint
+	Email              whereHelperstring
+	PasswordHash       whereHelperstring
+	CreatedAt          whereHelpertime_Time
+	UpdatedAt          whereHelpertime_Time
+	TotpSecret         whereHelperstring
+	TotpStep           whereHelperint64
+	RecoveryCodeHashes whereHelperstring
 }{
-	UserID:       whereHelperint{field: "\"users\".\"user_id\""},
-	Email:        whereHelperstring{field: "\"users\".\"email\""},
-	PasswordHash: whereHelperstring{field: "\"users\".\"password_hash\""},
-	CreatedAt:    whereHelpertime_Time{field: "\"users\".\"created_at\""},
-	UpdatedAt:    whereHelpertime_Time{field: "\"users\".\"updated_at\""},
+	UserID:             whereHelperint{field: "\"users\".\"user_id\""},
+	Email:              whereHelperstring{field: "\"users\".\"email\""},
+	PasswordHash:       whereHelperstring{field: "\"users\".\"password_hash\""},
+	CreatedAt:          whereHelpertime_Time{field: "\"users\".\"created_at\""},
+	UpdatedAt:          whereHelpertime_Time{field: "\"users\".\"updated_at\""},
+	TotpSecret:         whereHelperstring{field: "\"users\".\"totp_secret\""},
+	TotpStep:           whereHelperint64{field: "\"users\".\"totp_step\""},
+	RecoveryCodeHashes: whereHelperstring{field: "\"users\".\"recovery_code_hashes\""},
 }
 
 // UserRels is where relationship names are stored.
@@ -84,9 +122,9 @@ func (*userR) NewStruct() *userR {
 type userL struct{}
 
 var (
-	userAllColumns            = []string{"user_id", "email", "password_hash", "created_at", "updated_at"}
+	userAllColumns            = []string{"user_id", "email", "password_hash", "created_at", "updated_at", "totp_secret", "totp_step", "recovery_code_hashes"}
 	userColumnsWithoutDefault = []string{"email", "password_hash"}
-	userColumnsWithDefault    = []string{"user_id", "created_at", "updated_at"}
+	userColumnsWithDefault    = []string{"user_id", "created_at", "updated_at", "totp_secret", "totp_step", "recovery_code_hashes"}
 	userPrimaryKeyColumns     = []string{"user_id"}
 )
 
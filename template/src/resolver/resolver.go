@@ -4,11 +4,15 @@ import (
 	"context"
 	"template/app"
 	"template/loader"
+	"template/models"
 
 	"github.com/scott-rc/core"
 )
 
-type Resolver struct{}
+type Resolver struct {
+	// SendPasswordReset sends the password reset token to the user, usually as a link in an email.
+	SendPasswordReset func(c *app.Core, user *models.User, token string) error
+}
 
 func (r *Resolver) core(ctx context.Context, operation string) *app.Core {
 	c := &app.Core{
@@ -3,13 +3,15 @@ package resolver
 import (
 	"context"
 	"database/sql"
+	"strconv"
+	"strings"
 	"template/models"
 	"template/types"
+	"time"
 
 	"github.com/scott-rc/core"
 
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/volatiletech/sqlboiler/v4/boil"
 )
@@ -31,42 +33,190 @@ func (r *Resolver) Self(ctx context.Context) (*types.SelfType, error) {
 func (r *Resolver) SelfCreate(ctx context.Context, args *struct{ Self types.SelfCreateInputType }) (*types.SelfType, error) {
 	c := r.core(ctx, "resolver.SelfCreate")
 
-	err := c.Validate.Struct(args.Self)
+	err := c.Validate.Struct(args)
 	if err != nil {
 		return nil, err
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(args.Self.Password), bcrypt.DefaultCost)
+	hash, err := c.HashPassword(args.Self.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	user := &models.User{Email: args.Self.Email, PasswordHash: string(hash)}
+	user := &models.User{Email: args.Self.Email, PasswordHash: hash}
 
 	err = user.Insert(c.Context, c.Db, boil.Infer())
 	if err != nil {
 		return nil, err
 	}
+	c.Audit("self.create", strconv.Itoa(user.UserID), nil)
 
 	return types.NewSelfType(c, user), nil
 }
 
 func (r *Resolver) SelfLogin(ctx context.Context, args *struct{ Credentials types.SelfLoginInputType }) (*types.SelfType, error) {
 	c := r.core(ctx, "resolver.SelfLogin")
+
+	err := c.CheckLoginAttempts(args.Credentials.Email)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := models.Users(qm.Where("email = ?", args.Credentials.Email)).One(c.Context, c.Db)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			c.LoginFailed(args.Credentials.Email)
 			err = core.NewError(c.Core, err, core.KindInvalidCredentials)
 		}
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(args.Credentials.Password))
+	rehash, err := c.VerifyPassword(user.PasswordHash, args.Credentials.Password)
 	if err != nil {
-		return nil, core.NewError(c.Core, err, core.KindInvalidCredentials)
+		c.LoginFailed(args.Credentials.Email)
+		return nil, err
 	}
+	c.LoginSucceeded(args.Credentials.Email)
 
-	c.Session.Login(user.UserID)
+	if rehash != "" {
+		user.PasswordHash = rehash
+		_, err = user.Update(c.Context, c.Db, boil.Whitelist(models.UserColumns.PasswordHash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// users with a second factor aren't logged in until they verify it, see SelfVerifySecondFactor
+	if user.TotpSecret != "" {
+		c.Session.LoginPartially(strconv.Itoa(user.UserID))
+	} else {
+		c.Session.Login(user.UserID)
+	}
+
+	return types.NewSelfType(c, user), nil
+}
+
+func (r *Resolver) SelfVerifySecondFactor(ctx context.Context, args *struct{ Code string }) (*types.SelfType, error) {
+	c := r.core(ctx, "resolver.SelfVerifySecondFactor")
+	if !c.Session.IsPartiallyAuthenticated() {
+		return nil, core.NewError(c.Core, core.KindUnauthorized)
+	}
+
+	user, err := models.FindUser(c.Context, c.Db, c.Session.UserId())
+	if err != nil {
+		return nil, err
+	}
+
+	// codes are only 6 digits, so guessing them is throttled like guessing passwords
+	err = c.CheckLoginAttempts(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if step, ok := c.VerifyTotp(user.TotpSecret, args.Code, user.TotpStep); ok {
+		user.TotpStep = step
+	} else {
+		hashes := strings.Fields(user.RecoveryCodeHashes)
+		i := core.VerifyRecoveryCode(args.Code, hashes)
+		if i < 0 {
+			c.LoginFailed(user.Email)
+			return nil, core.NewError(c.Core, core.KindInvalidSecondFactor)
+		}
+		user.RecoveryCodeHashes = strings.Join(append(hashes[:i], hashes[i+1:]...), " ")
+		c.Audit("self.recovery_code_used", strconv.Itoa(user.UserID), map[string]interface{}{"remaining": len(hashes) - 1})
+	}
+	c.LoginSucceeded(user.Email)
+
+	_, err = user.Update(c.Context, c.Db, boil.Whitelist(models.UserColumns.TotpStep, models.UserColumns.RecoveryCodeHashes))
+	if err != nil {
+		return nil, err
+	}
+
+	c.Session.CompleteSecondFactor()
+
+	return types.NewSelfType(c, user), nil
+}
+
+func (r *Resolver) SelfPasswordResetRequest(ctx context.Context, args *struct{ Email string }) (int32, error) {
+	c := r.core(ctx, "resolver.SelfPasswordResetRequest")
+
+	// the response is the same whether the email exists or not, so it can't be used to find accounts
+	user, err := models.Users(qm.Where("email = ?", args.Email)).One(c.Context, c.Db)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	// the token stops working once the password changes, so each token can reset the password once
+	token, err := c.NewBoundSignedToken(core.TokenPurposePasswordReset, strconv.Itoa(user.UserID), user.PasswordHash)
+	if err != nil {
+		return 0, err
+	}
+
+	if r.SendPasswordReset == nil {
+		c.Logger.Error("Resolver.SendPasswordReset must be set to send password reset tokens")
+		return 0, nil
+	}
+	err = r.SendPasswordReset(c, user, token)
+	if err != nil {
+		return 0, err
+	}
+	c.Audit("self.password_reset_request", strconv.Itoa(user.UserID), nil)
+
+	return 0, nil
+}
+
+func (r *Resolver) SelfPasswordReset(ctx context.Context, args *struct {
+	Reset types.SelfPasswordResetInputType
+}) (*types.SelfType, error) {
+	c := r.core(ctx, "resolver.SelfPasswordReset")
+
+	err := c.Validate.Struct(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	subject, err := c.UseBoundSignedToken(core.TokenPurposePasswordReset, args.Reset.Token, func(subject string) (string, error) {
+		userId, err := strconv.Atoi(subject)
+		if err != nil {
+			return "", err
+		}
+		user, err = models.FindUser(c.Context, c.Db, userId)
+		if err != nil {
+			return "", err
+		}
+		return user.PasswordHash, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.PasswordHash, err = c.HashPassword(args.Reset.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = user.Update(c.Context, c.Db, boil.Whitelist(models.UserColumns.PasswordHash))
+	if err != nil {
+		return nil, err
+	}
+	c.Audit("self.password_reset", subject, nil)
+
+	// log out every other session, the old password may have been compromised
+	err = c.RevokeSubject(subject, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// resetting the password doesn't skip the second factor
+	if user.TotpSecret != "" {
+		c.Session.LoginPartially(subject)
+	} else {
+		c.Session.Login(user.UserID)
+	}
 
 	return types.NewSelfType(c, user), nil
 }
@@ -76,3 +226,12 @@ func (r *Resolver) SelfLogout(ctx context.Context) (int32, error) {
 	c.Session.Logout()
 	return 0, nil
 }
+
+func (r *Resolver) SessionRevoke(ctx context.Context, args *struct{ Id string }) (int32, error) {
+	c := r.core(ctx, "resolver.SessionRevoke")
+	err := c.Session.RevokeSession(args.Id)
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -42,7 +42,7 @@ func (r *Resolver) TodoCreate(ctx context.Context, args *struct{ Todo types.Todo
 		return nil, core.KindUnauthorized
 	}
 
-	err := c.Validate.Struct(args.Todo)
+	err := c.Validate.Struct(args)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +66,7 @@ func (r *Resolver) TodoUpdate(ctx context.Context, args *struct{ Todo types.Todo
 		return nil, core.KindUnauthorized
 	}
 
-	err := c.Validate.Struct(args.Todo)
+	err := c.Validate.Struct(args)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,39 @@
+package store_test
+
+import (
+	"database/sql"
+	"template/test/testsuite"
+	"testing"
+
+	"github.com/scott-rc/core"
+	"github.com/stretchr/testify/suite"
+)
+
+type apiKeyStoreTestSuite struct {
+	*testsuite.Suite
+}
+
+func TestApiKeyStore(t *testing.T) {
+	suite.Run(t, &apiKeyStoreTestSuite{&testsuite.Suite{}})
+}
+
+func (s *apiKeyStoreTestSuite) Test_Revoke() {
+	for name, store := range map[string]core.ApiKeyStore{
+		"memory": core.NewMemoryApiKeyStore(),
+		"sql":    core.NewSqlApiKeyStore(),
+	} {
+		// arrange
+		key := &core.ApiKey{Id: name, Name: "integration", Subject: "subject", Hash: "hash"}
+		s.NoError(store.Create(s.Core.Core, key), name)
+
+		// act & assert
+		s.Equal(sql.ErrNoRows, store.Revoke(s.Core.Core, name+"-missing"), name)
+		s.NoError(store.Revoke(s.Core.Core, key.Id), name)
+		// revoking it again is fine
+		s.NoError(store.Revoke(s.Core.Core, key.Id), name)
+
+		revoked, err := store.Find(s.Core.Core, key.Id)
+		s.NoError(err, name)
+		s.NotNil(revoked.RevokedAt, name)
+	}
+}
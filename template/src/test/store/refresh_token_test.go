@@ -0,0 +1,42 @@
+package store_test
+
+import (
+	"database/sql"
+	"template/test/testsuite"
+	"testing"
+	"time"
+
+	"github.com/scott-rc/core"
+	"github.com/stretchr/testify/suite"
+)
+
+type refreshTokenStoreTestSuite struct {
+	*testsuite.Suite
+}
+
+func TestRefreshTokenStore(t *testing.T) {
+	suite.Run(t, &refreshTokenStoreTestSuite{&testsuite.Suite{}})
+}
+
+func (s *refreshTokenStoreTestSuite) Test_Rotate() {
+	for name, store := range map[string]core.RefreshTokenStore{
+		"memory": core.NewMemoryRefreshTokenStore(),
+		"sql":    core.NewSqlRefreshTokenStore(),
+	} {
+		// arrange
+		now := time.Now().Truncate(time.Second)
+		token := &core.RefreshToken{Id: name + "-1", Family: name, Subject: "subject", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+		next := &core.RefreshToken{Id: name + "-2", Family: name, Subject: "subject", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}
+		s.NoError(store.Create(s.Core.Core, token), name)
+
+		// act & assert
+		s.Equal(sql.ErrNoRows, store.Rotate(s.Core.Core, name+"-missing", next), name)
+		s.NoError(store.Rotate(s.Core.Core, token.Id, next), name)
+		s.Equal(core.ErrRefreshTokenReused, store.Rotate(s.Core.Core, token.Id, next), name)
+
+		rotated, err := store.Find(s.Core.Core, token.Id)
+		s.NoError(err, name)
+		s.NotNil(rotated.RevokedAt, name)
+		s.Equal(next.Id, rotated.ReplacedBy, name)
+	}
+}
@@ -44,6 +44,14 @@ func (s *SelfType) Todos() ([]*TodoType, error) {
 	return NewTodoTypes(s.core, todos), nil
 }
 
+func (s *SelfType) Sessions() ([]*SessionType, error) {
+	sessions, err := s.core.Session.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	return NewSessionTypes(sessions), nil
+}
+
 type SelfCreateInputType struct {
 	Email    string `validate:"required,email"`
 	Password string `validate:"required,min=8"`
@@ -53,3 +61,8 @@ type SelfLoginInputType struct {
 	Email    string `validate:"required"`
 	Password string `validate:"required"`
 }
+
+type SelfPasswordResetInputType struct {
+	Token    string `validate:"required"`
+	Password string `validate:"required,min=8"`
+}
@@ -0,0 +1,50 @@
+package types
+
+import (
+	"github.com/graph-gophers/graphql-go"
+	"github.com/scott-rc/core"
+)
+
+type SessionType struct {
+	session core.DeviceSession
+}
+
+func NewSessionType(session core.DeviceSession) *SessionType {
+	return &SessionType{session: session}
+}
+
+func NewSessionTypes(sessions []core.DeviceSession) []*SessionType {
+	res := make([]*SessionType, len(sessions))
+	for i, session := range sessions {
+		res[i] = NewSessionType(session)
+	}
+	return res
+}
+
+func (s *SessionType) Id() string {
+	return s.session.Id
+}
+
+func (s *SessionType) Device() string {
+	return s.session.Device
+}
+
+func (s *SessionType) Ip() string {
+	return s.session.Ip
+}
+
+func (s *SessionType) UserAgent() string {
+	return s.session.UserAgent
+}
+
+func (s *SessionType) LastSeenAt() graphql.Time {
+	return graphql.Time{Time: s.session.LastSeenAt}
+}
+
+func (s *SessionType) ExpiresAt() graphql.Time {
+	return graphql.Time{Time: s.session.ExpiresAt}
+}
+
+func (s *SessionType) Current() bool {
+	return s.session.Current
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	nanoid "github.com/matoous/go-nanoid"
+)
+
+const (
+	// GrantTypeTokenExchange is the grant_type of a token exchange request (RFC 8693).
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// TokenTypeAccessToken indicates the subject_token is an access token issued by core.
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	// TokenTypeJwt indicates the actor_token is a service assertion (see NewServiceAssertion).
+	TokenTypeJwt = "urn:ietf:params:oauth:token-type:jwt"
+	// TokenTypeSubject indicates the subject_token is the subject itself. This lets a service act on behalf of a user
+	// without having one of their access tokens (e.g. in a background job).
+	TokenTypeSubject = "urn:scott-rc:core:params:oauth:token-type:subject"
+)
+
+// NewServiceAssertion returns a JWT that proves the caller is the given service. It's sent as the actor_token of a
+// token exchange request to mint a short-lived access token on behalf of a subject. Every assertion can only be used
+// once.
+//
+// The audience must be the issuer of core's access tokens (server.jwt.access_token.issuer).
+func NewServiceAssertion(serviceId, secret, audience string, ttl time.Duration) (string, error) {
+	id, err := nanoid.Nanoid()
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		Id:        id,
+		Issuer:    serviceId,
+		Subject:   serviceId,
+		Audience:  audience,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}).SignedString([]byte(secret))
+}
+
+// tokenExchange handles RFC 8693 token exchange requests from services.
+//
+// The request is form encoded and must contain:
+// - grant_type: urn:ietf:params:oauth:grant-type:token-exchange
+// - subject_token: an access token, or the subject itself
+// - subject_token_type: urn:ietf:params:oauth:token-type:access_token or urn:scott-rc:core:params:oauth:token-type:subject
+// - actor_token: a service assertion (see NewServiceAssertion)
+// - actor_token_type: urn:ietf:params:oauth:token-type:jwt
+//
+// It can also contain:
+// - audience: the audience of the access token, which must be one of the service's audiences
+//
+// The response contains an access token for the subject with an "act" claim that identifies the service.
+// Services can only use the subject itself as the subject_token for the subjects they're configured with. Access tokens
+// that are waiting for a second factor can't be exchanged, and impersonation access tokens stay impersonating.
+func (s *server) tokenExchange(w http.ResponseWriter, r *http.Request) {
+	core, err := s.newCore(w, r, "server.TokenExchange")
+	if err != nil {
+		writeOauthError(core, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+
+	if err = core.Request.ParseForm(); err != nil {
+		writeOauthError(core, http.StatusBadRequest, "invalid_request", NewError(core, err, KindInvalidTokenExchange))
+		return
+	}
+	form := core.Request.PostForm
+
+	if form.Get("grant_type") != GrantTypeTokenExchange {
+		writeOauthError(core, http.StatusBadRequest, "unsupported_grant_type", NewError(core, KindInvalidTokenExchange, "grant_type must be "+GrantTypeTokenExchange))
+		return
+	}
+
+	if form.Get("actor_token_type") != TokenTypeJwt {
+		writeOauthError(core, http.StatusBadRequest, "invalid_request", NewError(core, KindInvalidTokenExchange, "actor_token_type must be "+TokenTypeJwt))
+		return
+	}
+	serviceId, err := verifyServiceAssertion(core, form.Get("actor_token"))
+	if err != nil {
+		writeOauthError(core, http.StatusUnauthorized, "invalid_client", NewError(core, err, KindInvalidServiceCredentials))
+		return
+	}
+	service := core.Config.CoreConfig().Server.TokenExchange.Services[serviceId]
+
+	audience := form.Get("audience")
+	if audience != "" && !contains(service.Audiences, audience) {
+		writeOauthError(core, http.StatusBadRequest, "invalid_target", NewError(core, KindInvalidTokenExchange, "The service isn't allowed to request that audience"))
+		return
+	}
+
+	var subject string
+	var impersonator *actorClaim
+	switch form.Get("subject_token_type") {
+	case TokenTypeAccessToken:
+		token, err := parseToken(core, form.Get("subject_token"), false)
+		if err != nil {
+			writeOauthError(core, http.StatusBadRequest, "invalid_grant", NewError(core, err))
+			return
+		}
+		claims := token.Claims.(*tokenClaims)
+		if claims.Act != nil {
+			writeOauthError(core, http.StatusBadRequest, "invalid_grant", NewError(core, KindInvalidTokenExchange, "Delegated access tokens can't be exchanged again"))
+			return
+		}
+		if claims.SecondFactorPending {
+			writeOauthError(core, http.StatusBadRequest, "invalid_grant", NewError(core, KindSecondFactorRequired))
+			return
+		}
+		if err = checkDenylist(core, claims); err != nil {
+			writeOauthError(core, http.StatusBadRequest, "invalid_grant", err)
+			return
+		}
+		subject = claims.Subject
+		impersonator = claims.Imp
+	case TokenTypeSubject:
+		subject = form.Get("subject_token")
+		if subject != "" && !contains(service.Subjects, "*") && !contains(service.Subjects, subject) {
+			writeOauthError(core, http.StatusBadRequest, "unauthorized_client", NewError(core, KindInvalidTokenExchange, "The service isn't allowed to act on behalf of that subject"))
+			return
+		}
+	default:
+		writeOauthError(core, http.StatusBadRequest, "invalid_request", NewError(core, KindInvalidTokenExchange, "subject_token_type must be "+TokenTypeAccessToken+" or "+TokenTypeSubject))
+		return
+	}
+	if subject == "" {
+		writeOauthError(core, http.StatusBadRequest, "invalid_request", NewError(core, KindInvalidTokenExchange, "subject_token must not be empty"))
+		return
+	}
+
+	token := generateDelegatedToken(core, subject, serviceId)
+	claims := token.Claims.(*tokenClaims)
+	claims.Imp = impersonator
+	if audience != "" {
+		claims.Audience = audience
+	}
+	signed, err := token.SignedString([]byte(core.Config.CoreConfig().Server.Jwt.AccessToken.Secret))
+	if err != nil {
+		writeOauthError(core, http.StatusInternalServerError, "server_error", NewError(core, err))
+		return
+	}
+
+	core.Logger.Info("issued delegated access token", "service", serviceId, "subject", subject, "audience", claims.Audience)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":      signed,
+		"issued_token_type": TokenTypeAccessToken,
+		"token_type":        "Bearer",
+		"expires_in":        int(core.Config.CoreConfig().Server.TokenExchange.ExpiresAt.Seconds()),
+	})
+}
+
+// verifyServiceAssertion verifies the service assertion against the configured service secrets and returns the
+// service's id.
+func verifyServiceAssertion(c *Core, assertion string) (string, error) {
+	cfg := c.Config.CoreConfig().Server
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.NewValidationError(fmt.Sprintf("Unexpected signing method: %v", token.Header["alg"]), jwt.ValidationErrorSignatureInvalid)
+		}
+		service, ok := cfg.TokenExchange.Services[claims.Issuer]
+		if !ok {
+			return nil, jwt.NewValidationError("unknown service", jwt.ValidationErrorIssuer)
+		}
+		return []byte(service.Secret), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if claims.ExpiresAt == 0 || claims.ExpiresAt-claims.IssuedAt > int64(cfg.TokenExchange.MaxAssertionAge.Seconds()) {
+		return "", jwt.NewValidationError("service assertion must expire within server.token_exchange.max_assertion_age", jwt.ValidationErrorExpired)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Audience), []byte(cfg.Jwt.AccessToken.Issuer)) != 1 {
+		return "", jwt.NewValidationError("service assertion has an invalid audience", jwt.ValidationErrorAudience)
+	}
+	if claims.Subject != claims.Issuer {
+		return "", jwt.NewValidationError("service assertion's subject must be its issuer", jwt.ValidationErrorClaimsInvalid)
+	}
+	if claims.Id == "" {
+		return "", jwt.NewValidationError("service assertion must have an id (jti)", jwt.ValidationErrorId)
+	}
+
+	// assertions can't be replayed, even before they expire
+	if err = usedTokens.Use(c, "service_assertion:"+claims.Issuer+":"+claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return "", err
+	}
+
+	return claims.Issuer, nil
+}
+
+// generateDelegatedToken generates a short-lived access token for the subject that the service acts on behalf of.
+func generateDelegatedToken(core *Core, subject, serviceId string) *jwt.Token {
+	token := generateToken(core, subject, false)
+	claims := token.Claims.(*tokenClaims)
+	claims.Act = &actorClaim{Subject: serviceId}
+	claims.ExpiresAt = time.Now().Add(core.Config.CoreConfig().Server.TokenExchange.ExpiresAt).Unix()
+	return token
+}
+
+// contains reports whether the values contain the value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOauthError writes an OAuth 2.0 error response (RFC 6749 section 5.2).
+func writeOauthError(c *Core, status int, code string, err error) {
+	c.w.Header().Set("Content-Type", "application/json")
+	c.w.Header().Set("Cache-Control", "no-store")
+	c.w.WriteHeader(status)
+	_ = json.NewEncoder(c.w).Encode(map[string]string{
+		"error":             code,
+		"error_description": err.Error(),
+	})
+}
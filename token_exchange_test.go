@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+)
+
+const tokenExchangeTestSecret = "billing_service_secret_for_tests"
+
+func newTokenExchangeTestServer() *server {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.TokenExchange = &TokenExchangeConfig{
+		ExpiresAt:       time.Minute,
+		MaxAssertionAge: time.Minute,
+		Services: map[string]ServiceConfig{
+			"billing": {Secret: tokenExchangeTestSecret, Subjects: []string{"1"}, Audiences: []string{"billing"}},
+		},
+	}
+	return &server{
+		config:   c.Config,
+		logger:   newLogger(c.Config.CoreConfig()),
+		decorate: func(ctx context.Context) context.Context { return ctx },
+		router:   chi.NewRouter(),
+	}
+}
+
+// exchangeToken sends a token exchange request and returns the response's status and body.
+func exchangeToken(t *testing.T, s *server, form url.Values) (int, map[string]interface{}) {
+	if form.Get("actor_token") == "" {
+		assertion, err := NewServiceAssertion("billing", tokenExchangeTestSecret, "test", time.Minute)
+		require.NoError(t, err)
+		form.Set("actor_token", assertion)
+	}
+	form.Set("grant_type", GrantTypeTokenExchange)
+	form.Set("actor_token_type", TokenTypeJwt)
+
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	s.tokenExchange(rec, req)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec.Code, body
+}
+
+// delegatedClaims parses the access token of a successful token exchange.
+func delegatedClaims(t *testing.T, body map[string]interface{}) *tokenClaims {
+	token, err := parseToken(newTestCore(), body["access_token"].(string), false)
+	require.NoError(t, err)
+	return token.Claims.(*tokenClaims)
+}
+
+func TestTokenExchange_Subject(t *testing.T) {
+	defer func(s UsedTokenStore) { usedTokens = s }(usedTokens)
+	usedTokens = NewMemoryUsedTokenStore()
+	s := newTokenExchangeTestServer()
+
+	status, body := exchangeToken(t, s, url.Values{"subject_token": {"1"}, "subject_token_type": {TokenTypeSubject}})
+	require.Equal(t, http.StatusOK, status, body)
+	claims := delegatedClaims(t, body)
+	require.Equal(t, "1", claims.Subject)
+	require.Equal(t, "billing", claims.Act.Subject)
+	require.Equal(t, "*", claims.Audience)
+
+	// services can only act on behalf of their subjects
+	status, body = exchangeToken(t, s, url.Values{"subject_token": {"2"}, "subject_token_type": {TokenTypeSubject}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "unauthorized_client", body["error"])
+
+	s.config.CoreConfig().Server.TokenExchange.Services["billing"] = ServiceConfig{Secret: tokenExchangeTestSecret, Subjects: []string{"*"}}
+	status, body = exchangeToken(t, s, url.Values{"subject_token": {"2"}, "subject_token_type": {TokenTypeSubject}})
+	require.Equal(t, http.StatusOK, status, body)
+}
+
+func TestTokenExchange_Audience(t *testing.T) {
+	defer func(s UsedTokenStore) { usedTokens = s }(usedTokens)
+	usedTokens = NewMemoryUsedTokenStore()
+	s := newTokenExchangeTestServer()
+
+	status, body := exchangeToken(t, s, url.Values{"subject_token": {"1"}, "subject_token_type": {TokenTypeSubject}, "audience": {"billing"}})
+	require.Equal(t, http.StatusOK, status, body)
+	require.Equal(t, "billing", delegatedClaims(t, body).Audience)
+
+	status, body = exchangeToken(t, s, url.Values{"subject_token": {"1"}, "subject_token_type": {TokenTypeSubject}, "audience": {"admin"}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_target", body["error"])
+}
+
+func TestTokenExchange_AccessToken(t *testing.T) {
+	defer func(s UsedTokenStore) { usedTokens = s }(usedTokens)
+	usedTokens = NewMemoryUsedTokenStore()
+	s := newTokenExchangeTestServer()
+	c := newTestCore()
+
+	sign := func(claims *tokenClaims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(c.Config.CoreConfig().Server.Jwt.AccessToken.Secret))
+		require.NoError(t, err)
+		return signed
+	}
+
+	// access tokens can be exchanged for subjects the service isn't configured with
+	token := generateToken(c, "2", false)
+	status, body := exchangeToken(t, s, url.Values{"subject_token": {sign(token.Claims.(*tokenClaims))}, "subject_token_type": {TokenTypeAccessToken}})
+	require.Equal(t, http.StatusOK, status, body)
+	require.Equal(t, "2", delegatedClaims(t, body).Subject)
+	require.Nil(t, delegatedClaims(t, body).Imp)
+
+	// impersonation access tokens stay impersonating
+	token = generateToken(c, "2", false)
+	token.Claims.(*tokenClaims).Imp = &actorClaim{Subject: "support"}
+	status, body = exchangeToken(t, s, url.Values{"subject_token": {sign(token.Claims.(*tokenClaims))}, "subject_token_type": {TokenTypeAccessToken}})
+	require.Equal(t, http.StatusOK, status, body)
+	require.Equal(t, &actorClaim{Subject: "support"}, delegatedClaims(t, body).Imp)
+
+	// access tokens that are waiting for a second factor can't be exchanged
+	token = generateToken(c, "2", false)
+	token.Claims.(*tokenClaims).SecondFactorPending = true
+	status, body = exchangeToken(t, s, url.Values{"subject_token": {sign(token.Claims.(*tokenClaims))}, "subject_token_type": {TokenTypeAccessToken}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_grant", body["error"])
+
+	// delegated access tokens can't be exchanged again
+	token = generateToken(c, "2", false)
+	token.Claims.(*tokenClaims).Act = &actorClaim{Subject: "billing"}
+	status, body = exchangeToken(t, s, url.Values{"subject_token": {sign(token.Claims.(*tokenClaims))}, "subject_token_type": {TokenTypeAccessToken}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestTokenExchange_AssertionReplay(t *testing.T) {
+	defer func(s UsedTokenStore) { usedTokens = s }(usedTokens)
+	usedTokens = NewMemoryUsedTokenStore()
+	s := newTokenExchangeTestServer()
+
+	assertion, err := NewServiceAssertion("billing", tokenExchangeTestSecret, "test", time.Minute)
+	require.NoError(t, err)
+	form := url.Values{"subject_token": {"1"}, "subject_token_type": {TokenTypeSubject}, "actor_token": {assertion}}
+
+	status, body := exchangeToken(t, s, form)
+	require.Equal(t, http.StatusOK, status, body)
+	status, body = exchangeToken(t, s, form)
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, "invalid_client", body["error"])
+
+	// assertions signed with the wrong secret are rejected
+	assertion, err = NewServiceAssertion("billing", "not_the_billing_service_secret", "test", time.Minute)
+	require.NoError(t, err)
+	form.Set("actor_token", assertion)
+	status, _ = exchangeToken(t, s, form)
+	require.Equal(t, http.StatusUnauthorized, status)
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"fmt"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+// Validation is a custom validation rule that can be used in validate tags.
+type Validation struct {
+	// Tag is the name of the rule (e.g. "strong_password").
+	Tag string
+	// Func reports whether the field is valid. The context is the one given to Validate.StructCtx, so validating with
+	// *core.Core.Context gives it access to the request's *core.Core through core.ContextKey.
+	Func validator.FuncCtx
+	// CallEvenIfNull indicates whether Func should be called for nil fields.
+	CallEvenIfNull bool
+	// Messages are the rule's messages keyed by locale (e.g. {"en": "{0} must contain a letter and a number"}).
+	// {0} is replaced by the field's name and {1} by the rule's parameter.
+	Messages map[string]string
+}
+
+// StructValidation is a custom validation rule for whole structs, e.g. to validate fields that depend on each other.
+// Errors are reported with validator.StructLevel.ReportError, and their messages are given through
+// core.Options.ValidationMessages.
+type StructValidation struct {
+	// Func validates the struct.
+	Func validator.StructLevelFuncCtx
+	// Types are the structs that Func validates.
+	Types []interface{}
+}
+
+// Validator returns the validator that *core.Core.Validate is set to, including the rules registered through
+// RegisterValidations.
+func Validator() *validator.Validate {
+	return validate
+}
+
+// RegisterValidations adds the custom validation rules and messages of the options to the validator. It's called when
+// the server starts, and by coretest.NewCore with the rules of its options, so it rarely needs to be called separately.
+//
+// Messages for locales that aren't supported (see server.locale) are ignored.
+func RegisterValidations(opts Options) error {
+	for _, validation := range opts.Validations {
+		if err := validate.RegisterValidationCtx(validation.Tag, validation.Func, validation.CallEvenIfNull); err != nil {
+			return err
+		}
+		if err := registerValidationMessages(validation.Tag, validation.Messages); err != nil {
+			return err
+		}
+	}
+
+	for _, validation := range opts.StructValidations {
+		validate.RegisterStructValidationCtx(validation.Func, validation.Types...)
+	}
+
+	for tag, messages := range opts.ValidationMessages {
+		if err := registerValidationMessages(tag, messages); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// registerValidationMessages registers the messages of a rule, replacing any existing ones.
+func registerValidationMessages(tag string, messages map[string]string) error {
+	for locale, message := range messages {
+		trans, found := uni.GetTranslator(locale)
+		if !found {
+			continue
+		}
+
+		message := message
+		err := validate.RegisterTranslation(tag, trans,
+			func(trans ut.Translator) error {
+				return trans.Add(tag, message, true)
+			},
+			func(trans ut.Translator, fe validator.FieldError) string {
+				translation, err := trans.T(tag, fe.Field(), fe.Param())
+				if err != nil {
+					return fe.(error).Error()
+				}
+				return translation
+			})
+		if err != nil {
+			return fmt.Errorf("failed to register the %s message of %s: %w", locale, tag, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+)
+
+type testSignUpInput struct {
+	Password        string `validate:"test_strong_password"`
+	ConfirmPassword string
+}
+
+func TestRegisterValidations(t *testing.T) {
+	defer setupTestLocales(t)()
+	// the test rules are registered on their own validator, so they don't leak into other tests
+	defer func(v *validator.Validate) { validate = v }(validate)
+	validate = newValidator()
+
+	require.NoError(t, RegisterValidations(Options{
+		Validations: []Validation{{
+			Tag: "test_strong_password",
+			Func: func(ctx context.Context, fl validator.FieldLevel) bool {
+				// the request's core is available when validating with its context
+				require.NotNil(t, ctx.Value(ContextKey))
+				password := fl.Field().String()
+				return strings.ContainsAny(password, "0123456789") && strings.ToLower(password) != password
+			},
+			Messages: map[string]string{
+				"en": "{0} must contain a number and an upper case letter",
+				"fr": "{0} doit contenir un chiffre et une majuscule",
+				"de": "ignored because it isn't supported",
+			},
+		}},
+		StructValidations: []StructValidation{{
+			Func: func(ctx context.Context, sl validator.StructLevel) {
+				input := sl.Current().Interface().(testSignUpInput)
+				if input.Password != input.ConfirmPassword {
+					sl.ReportError(input.ConfirmPassword, "ConfirmPassword", "ConfirmPassword", "test_passwords_match", "")
+				}
+			},
+			Types: []interface{}{testSignUpInput{}},
+		}},
+		ValidationMessages: map[string]map[string]string{
+			"test_passwords_match": {"en": "{0} must match the password"},
+		},
+	}))
+
+	c := newTestCore()
+	c.Validate = Validator()
+	c.Context = context.WithValue(c.Context, ContextKey, c)
+
+	args := &struct{ Input testSignUpInput }{Input: testSignUpInput{Password: "password", ConfirmPassword: "passw0rd"}}
+	e := NewError(c, c.Validate.StructCtx(c.Context, args))
+	require.Equal(t, KindStructValidation, e.Kind)
+	require.Equal(t, []string{
+		"Password must contain a number and an upper case letter",
+		"ConfirmPassword must match the password",
+	}, e.Details)
+	require.Equal(t, "input.confirmPassword", e.Fields[1].Path)
+	require.Equal(t, "test_passwords_match", e.Fields[1].Rule)
+
+	c.Locale = "fr"
+	e = NewError(c, c.Validate.StructCtx(c.Context, args))
+	require.Equal(t, "Password doit contenir un chiffre et une majuscule", e.Details[0])
+
+	args.Input = testSignUpInput{Password: "Passw0rd", ConfirmPassword: "Passw0rd"}
+	require.NoError(t, c.Validate.StructCtx(c.Context, args))
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	nanoid "github.com/matoous/go-nanoid"
+)
+
+const visitorIdKey = "visitor_id"
+
+// VisitorMergeHook is called when a visitor logs in, so anything that was stored for the anonymous visitor (e.g. a
+// cart or a draft) can be moved to the subject.
+type VisitorMergeHook func(c *Core, visitorId, subject string)
+
+// visitorMergeHook is called whenever a visitor logs in.
+var visitorMergeHook VisitorMergeHook
+
+// visitorId returns the id of the visitor that made the request. If the request doesn't have a valid visitor id
+// cookie, a new visitor id is issued.
+//
+// It returns an empty string if server.visitor isn't configured.
+func visitorId(c *Core) string {
+	if c.visitorId != "" || c.Config.CoreConfig().Server.Visitor == nil {
+		return c.visitorId
+	}
+
+	if id := getVisitorId(c); id != "" {
+		c.visitorId = id
+		return id
+	}
+
+	// this should never error
+	id, _ := nanoid.Nanoid()
+	setVisitorId(c, id)
+	c.visitorId = id
+	c.Logger.Debug("issued visitor id", "visitorId", id)
+	return id
+}
+
+// getVisitorId returns the visitor id within the request's cookie, or an empty string if there isn't a valid one.
+func getVisitorId(c *Core) string {
+	cfg := c.Config.CoreConfig().Server.Visitor
+	if cfg == nil || c.Request == nil {
+		return ""
+	}
+
+	cookie, err := c.Request.Cookie(visitorIdKey)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(signVisitorId(cfg.Secret, parts[0]))) {
+		c.Logger.Info("ignoring visitor id with an invalid signature", "visitorId", cookie.Value)
+		return ""
+	}
+	return parts[0]
+}
+
+// setVisitorId sends the signed visitor id to the client as a cookie.
+func setVisitorId(c *Core, id string) {
+	cfg := c.Config.CoreConfig().Server.Visitor
+	if c.w == nil {
+		return
+	}
+
+	http.SetCookie(c.w, &http.Cookie{
+		Name:     visitorIdKey,
+		Value:    id + "." + signVisitorId(cfg.Secret, id),
+		MaxAge:   int(cfg.ExpiresAt.Seconds()),
+		SameSite: sameSiteMode(cfg.Cookie.SameSite),
+		Domain:   cfg.Cookie.Domain,
+		Path:     cfg.Cookie.Path,
+		Secure:   cfg.Cookie.Secure,
+		HttpOnly: true,
+	})
+}
+
+// signVisitorId
+func signVisitorId(secret, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// mergeVisitor calls the VisitorMergeHook if the request was made by a visitor.
+// Visitors without a visitor id cookie haven't stored anything, so a new visitor id isn't issued.
+func mergeVisitor(c *Core, subject string) {
+	if visitorMergeHook == nil {
+		return
+	}
+
+	id := c.visitorId
+	if id == "" {
+		id = getVisitorId(c)
+	}
+	if id != "" {
+		visitorMergeHook(c, id, subject)
+	}
+}
+
+// VisitorId
+func (s *session) VisitorId() string {
+	return visitorId(s.core)
+}
+
+// VisitorId
+func (s *opaqueSession) VisitorId() string {
+	return visitorId(s.core)
+}
+
+// VisitorId always returns an empty string, API keys aren't used by visitors.
+func (s *apiKeySession) VisitorId() string {
+	return ""
+}
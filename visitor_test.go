@@ -0,0 +1,74 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newVisitorTestCore(cookie *http.Cookie) *Core {
+	c := newTestCore()
+	c.Config.CoreConfig().Server.Visitor = &VisitorConfig{
+		Secret:    "visitor_secret_for_tests",
+		ExpiresAt: 24 * time.Hour,
+		Cookie:    CookieConfig{Path: "/"},
+	}
+	if cookie != nil {
+		c.Request.AddCookie(cookie)
+	}
+	return c
+}
+
+func visitorCookie(c *Core) *http.Cookie {
+	return responseCookie(c, visitorIdKey)
+}
+
+func TestSession_VisitorId(t *testing.T) {
+	first := newVisitorTestCore(nil)
+	id := first.Session.VisitorId()
+	require.NotEmpty(t, id)
+	require.Equal(t, id, first.Session.VisitorId())
+	cookie := visitorCookie(first)
+	require.NotNil(t, cookie)
+	require.True(t, cookie.HttpOnly)
+
+	// the same visitor keeps its id and isn't sent a new cookie
+	second := newVisitorTestCore(cookie)
+	require.Equal(t, id, second.Session.VisitorId())
+	require.Nil(t, visitorCookie(second))
+
+	// a forged visitor id is replaced
+	forged := newVisitorTestCore(&http.Cookie{Name: visitorIdKey, Value: "someone_else." + signVisitorId("wrong", "someone_else")})
+	require.NotEqual(t, "someone_else", forged.Session.VisitorId())
+	require.NotNil(t, visitorCookie(forged))
+
+	// visitor ids aren't issued without configuration
+	c := newTestCore()
+	require.Empty(t, c.Session.VisitorId())
+	require.Nil(t, visitorCookie(c))
+}
+
+func TestSession_LoginMergesVisitor(t *testing.T) {
+	defer func(s RefreshTokenStore) { refreshTokens = s }(refreshTokens)
+	refreshTokens = NewMemoryRefreshTokenStore()
+	defer func(h VisitorMergeHook) { visitorMergeHook = h }(visitorMergeHook)
+
+	var merged []string
+	visitorMergeHook = func(c *Core, visitorId, subject string) {
+		merged = append(merged, visitorId, subject)
+	}
+
+	// visitors that never got an id have nothing to merge
+	c := newVisitorTestCore(nil)
+	c.Session.LoginSubject("subject")
+	require.Empty(t, merged)
+	require.Nil(t, visitorCookie(c))
+
+	visitor := newVisitorTestCore(nil)
+	id := visitor.Session.VisitorId()
+	c = newVisitorTestCore(visitorCookie(visitor))
+	c.Session.LoginSubject("subject")
+	require.Equal(t, []string{id, "subject"}, merged)
+}